@@ -0,0 +1,139 @@
+package grpcweb
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// CallOption configures a call. Unlike ClientOption, it is specified per call.
+type CallOption func(*callInfo)
+
+type callInfo struct {
+	peer    *peer.Peer
+	header  *metadata.MD
+	trailer *metadata.MD
+
+	// path overrides the endpoint of the request if it is not empty.
+	path string
+
+	// maxRecvMsgSize is the max size of a received message. Zero means no limit.
+	maxRecvMsgSize int
+
+	progress      func(bytesReceived int64)
+	bytesReceived int64
+}
+
+func newCallInfo(opts []CallOption) *callInfo {
+	ci := &callInfo{}
+	for _, opt := range opts {
+		opt(ci)
+	}
+	return ci
+}
+
+// Peer returns a CallOption which stores the server which served the call into p.
+// p is populated after the request is sent, by transports which send requests through net/http.
+// If the connection is secured by TLS, p.AuthInfo is credentials.TLSInfo.
+func Peer(p *peer.Peer) CallOption {
+	return func(ci *callInfo) {
+		ci.peer = p
+	}
+}
+
+// Header returns a CallOption which stores the response headers (initial metadata) into md.
+// md is populated once the response headers are received. It is applied to unary and server streaming APIs.
+func Header(md *metadata.MD) CallOption {
+	return func(ci *callInfo) {
+		ci.header = md
+	}
+}
+
+// Trailer returns a CallOption which stores the trailer (trailing metadata) into md.
+// md is populated once the trailer frame is received, even if the status is not OK.
+// For server streaming APIs, it is populated when Receive returns the end of the stream.
+func Trailer(md *metadata.MD) CallOption {
+	return func(ci *callInfo) {
+		ci.trailer = md
+	}
+}
+
+// CallPath returns a CallOption which sends the request to path instead of the endpoint of the request.
+// It is useful for gateways which rewrite method paths. The leading slash is added if it is missing,
+// and the base path of WithBasePath is still prepended. It is applied to unary and server streaming APIs.
+func CallPath(path string) CallOption {
+	return func(ci *callInfo) {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		ci.path = path
+	}
+}
+
+// MaxCallRecvMsgSize returns a CallOption which limits the size of each message the client can receive.
+// A larger message results in a ResourceExhausted error. It is applied to unary and server streaming APIs.
+func MaxCallRecvMsgSize(n int) CallOption {
+	return func(ci *callInfo) {
+		ci.maxRecvMsgSize = n
+	}
+}
+
+// Progress returns a CallOption which reports the total bytes of the response frames received so far to f,
+// each time a frame is read. It is useful to show the progress of large server streams.
+// It is applied to unary and server streaming APIs.
+func Progress(f func(bytesReceived int64)) CallOption {
+	return func(ci *callInfo) {
+		ci.progress = f
+	}
+}
+
+// withTrace returns a copy of ctx which carries a httptrace.ClientTrace to populate the call info.
+func (ci *callInfo) withTrace(ctx context.Context) context.Context {
+	if ci.peer == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ci.peer.Addr = info.Conn.RemoteAddr()
+			if conn, ok := info.Conn.(*tls.Conn); ok {
+				ci.peer.AuthInfo = credentials.TLSInfo{State: conn.ConnectionState()}
+			}
+		},
+	})
+}
+
+// request returns a copy of req whose endpoint is overridden by CallPath, or req itself if CallPath is not specified.
+func (ci *callInfo) request(req *Request) *Request {
+	if ci.path == "" {
+		return req
+	}
+	r := *req
+	r.endpoint = ci.path
+	return &r
+}
+
+// received reports n more received bytes to the callback of Progress.
+func (ci *callInfo) received(n int) {
+	if ci.progress == nil {
+		return
+	}
+	ci.bytesReceived += int64(n)
+	ci.progress(ci.bytesReceived)
+}
+
+func (ci *callInfo) setHeader(md metadata.MD) {
+	if ci.header != nil {
+		*ci.header = md
+	}
+}
+
+func (ci *callInfo) setTrailer(md metadata.MD) {
+	if ci.trailer != nil && md != nil {
+		*ci.trailer = md
+	}
+}
@@ -1,26 +1,58 @@
 package grpcweb
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding"
 	pb "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type ClientOption func(*Client)
 
 func WithTransportBuilder(b TransportBuilder) ClientOption {
 	return func(c *Client) {
-		c.tb = b
+		c.tb = func(host string, req *Request, _ *TransportOptions) Transport {
+			return b(host, req)
+		}
 	}
 }
 
 func WithStreamTransportBuilder(b StreamTransportBuilder) ClientOption {
+	return func(c *Client) {
+		c.stb = func(_ context.Context, host string, endpoint string, _ *TransportOptions) (StreamTransport, error) {
+			return b(host, endpoint)
+		}
+	}
+}
+
+// WithTransportBuilderWithOptions is same as WithTransportBuilder, but b also receives TransportOptions
+// configured by other ClientOptions such as WithTLSConfig.
+func WithTransportBuilderWithOptions(b TransportBuilderWithOptions) ClientOption {
+	return func(c *Client) {
+		c.tb = b
+	}
+}
+
+// WithStreamTransportBuilderWithOptions is same as WithStreamTransportBuilder, but b also receives TransportOptions
+// configured by other ClientOptions such as WithTLSConfig.
+func WithStreamTransportBuilderWithOptions(b StreamTransportBuilderWithOptions) ClientOption {
 	return func(c *Client) {
 		c.stb = b
 	}
@@ -32,13 +64,444 @@ func WithCodec(codec encoding.Codec) ClientOption {
 	}
 }
 
+// WithCompressor compresses request messages by comp, and sends its name as grpc-encoding header.
+// Compressed response messages are decompressed by the compressor named by grpc-encoding response header,
+// or comp if the header is absent.
+// Compressors registered by encoding.RegisterCompressor can be found by encoding.GetCompressor.
+// (e.g. encoding.GetCompressor("gzip") after importing google.golang.org/grpc/encoding/gzip)
+// zstd and snappy are registered by importing grpcweb/encoding/zstd and grpcweb/encoding/snappy.
+func WithCompressor(comp encoding.Compressor) ClientOption {
+	return func(c *Client) {
+		c.compressor = comp
+		c.topts.Compression = comp.Name()
+	}
+}
+
+// WithAcceptCompressors sends names as grpc-accept-encoding header, so that the server can compress responses
+// by one of them even if request messages are not compressed. The compressor specified by WithCompressor is also sent.
+// The response messages are decompressed by the compressor named by grpc-encoding response header.
+// Names of compressors which are not registered by encoding.RegisterCompressor are ignored, since they can't decompress responses.
+func WithAcceptCompressors(names ...string) ClientOption {
+	return func(c *Client) {
+		for _, name := range names {
+			if encoding.GetCompressor(name) != nil {
+				c.topts.AcceptCompression = append(c.topts.AcceptCompression, name)
+			}
+		}
+	}
+}
+
+// WithCompressionThreshold skips compressing request messages smaller than n bytes even if WithCompressor is specified,
+// because the overhead of compression exceeds the savings for tiny messages. Such messages are sent uncompressed.
+func WithCompressionThreshold(n int) ClientOption {
+	return func(c *Client) {
+		c.compressThreshold = n
+	}
+}
+
+// WithContentType overrides the content-type of requests.
+// By default, it is derived from the codec. (e.g. "application/grpc-web+proto" for the proto codec)
+// "application/grpc-web-text" makes the unary transport encode requests and decode responses in base64.
+func WithContentType(contentType string) ClientOption {
+	return func(c *Client) {
+		c.topts.ContentType = contentType
+	}
+}
+
+// WithBasePath prepends prefix to the method path of requests in both of HTTP and WebSocket transports.
+// It is useful for gateways which mount gRPC Web under a path. (e.g. "/grpc" for "/grpc/package.Service/Method")
+func WithBasePath(prefix string) ClientOption {
+	return func(c *Client) {
+		c.topts.BasePath = prefix
+	}
+}
+
+// WithAuthority overrides the Host header (:authority in HTTP/2) of requests and WebSocket opening handshakes,
+// while the client still connects to the host passed to NewClient. It is useful for host-based routing of load balancers.
+// The server name of TLS is not changed. Set it by WithTLSConfig if needed.
+func WithAuthority(authority string) ClientOption {
+	return func(c *Client) {
+		c.topts.Authority = authority
+	}
+}
+
+// WithFollowRedirects makes the unary transport follow redirect responses (e.g. from http to https),
+// re-sending the request with the same method, body and headers. Credentials such as Authorization header
+// are sent only to the same host. By default, a redirect results in RedirectError which has the target URL.
+func WithFollowRedirects() ClientOption {
+	return func(c *Client) {
+		c.topts.FollowRedirects = true
+	}
+}
+
+// WithXGRPCWebHeader overrides the value of x-grpc-web header of requests.
+// The default value is "1".
+func WithXGRPCWebHeader(v string) ClientOption {
+	return func(c *Client) {
+		c.topts.XGRPCWeb = v
+	}
+}
+
+// WithDisableXGRPCWebHeader stops sending x-grpc-web header in both of unary requests and streams.
+// It is a workaround for proxies which reject the header.
+func WithDisableXGRPCWebHeader() ClientOption {
+	return func(c *Client) {
+		c.topts.DisableXGRPCWeb = true
+	}
+}
+
+// WithDisableTETrailers stops sending "te: trailers" header in unary and server streaming requests.
+// By default, it is sent to indicate that the client understands trailers.
+// It is a workaround for misbehaving proxies which reject the header.
+func WithDisableTETrailers() ClientOption {
+	return func(c *Client) {
+		c.topts.DisableTETrailers = true
+	}
+}
+
+// WithDrainTimeout specifies how long closing a stream waits for draining remaining frames
+// before the connection is closed forcibly.
+// By default, the connection is closed immediately.
+func WithDrainTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.topts.DrainTimeout = d
+	}
+}
+
+// WithStreamSendTimeout specifies the timeout for each Send of client streaming and bidirectional streaming APIs.
+// A Send which is blocked longer than the timeout (e.g. the send buffer is full) returns an error.
+func WithStreamSendTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.topts.SendTimeout = d
+	}
+}
+
+// WithDialTimeout specifies the timeout for establishing WebSocket connections of streaming APIs,
+// including the opening handshake. By default, dialing may block until the OS gives up.
+func WithDialTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.topts.DialTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout bounds how long the unary transport waits for the response headers after sending a request,
+// separately from the deadline of the call. It is useful for gateways which accept connections but never respond.
+// When it expires, the call fails with DeadlineExceeded. Reading the response body, e.g. of server streams, is not limited.
+func WithResponseHeaderTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.topts.ResponseHeaderTimeout = d
+	}
+}
+
+// WithUserAgent overrides the User-Agent header of unary requests and WebSocket opening handshakes.
+// By default, "grpc-web-go-client/{Version}" is sent.
+func WithUserAgent(ua string) ClientOption {
+	return func(c *Client) {
+		c.topts.UserAgent = ua
+	}
+}
+
+// WithWebSocketSubprotocol overrides the subprotocol requested in WebSocket opening handshakes.
+// By default, "grpc-websockets" is requested.
+func WithWebSocketSubprotocol(protocol string) ClientOption {
+	return func(c *Client) {
+		c.topts.WebSocketSubprotocol = protocol
+	}
+}
+
+// WithWebSocketHeader adds a header sent in WebSocket opening handshakes, such as Origin or an auth token.
+// It may be specified multiple times.
+func WithWebSocketHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		if c.topts.WebSocketHeader == nil {
+			c.topts.WebSocketHeader = http.Header{}
+		}
+		c.topts.WebSocketHeader.Add(key, value)
+	}
+}
+
+// WithWebSocketMaxMessageSize splits requests of streaming APIs into WebSocket messages of at most n bytes,
+// for proxies which cap the size of WebSocket messages. n must be larger than 1.
+// The gRPC Web framing is preserved, since the server concatenates the messages into the request stream.
+func WithWebSocketMaxMessageSize(n int) ClientOption {
+	return func(c *Client) {
+		c.topts.WebSocketMaxMessageSize = n
+	}
+}
+
+// WithKeepalive makes WebSocket connections of streaming APIs send a ping message at every interval,
+// so that idle connections are not closed by intermediary proxies.
+// If the pong doesn't arrive within timeout while receiving responses, the connection is treated as broken and closed.
+func WithKeepalive(interval, timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.topts.KeepaliveInterval = interval
+		c.topts.KeepaliveTimeout = timeout
+	}
+}
+
+// WithInsecure disables transport security for the client.
+// It takes precedence over WithTLSConfig, so that the TLS configuration is ignored.
+func WithInsecure() ClientOption {
+	return func(c *Client) {
+		c.topts.Insecure = true
+	}
+}
+
+// WithTLSConfig specifies the TLS configuration used by both of HTTP and WebSocket transports.
+// It is useful to present client certificates (mTLS) or to pin CA certificates.
+func WithTLSConfig(cfg *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.topts.TLSConfig = cfg
+	}
+}
+
+// WithInsecureSkipVerify makes both of HTTP and WebSocket transports skip the verification of server certificates.
+// It is for local development against gateways with self-signed certificates. Never use it in production,
+// since it makes the connection vulnerable to man-in-the-middle attacks. It is combined with WithTLSConfig.
+func WithInsecureSkipVerify() ClientOption {
+	return func(c *Client) {
+		c.topts.InsecureSkipVerify = true
+	}
+}
+
+// WithHTTP2 forces the unary transport to speak HTTP/2 instead of negotiating the protocol.
+// Some gateways deliver trailers correctly only over HTTP/2.
+// With WithInsecure, HTTP/2 is spoken over cleartext TCP (h2c), so the server must accept it.
+func WithHTTP2() ClientOption {
+	return func(c *Client) {
+		c.topts.HTTP2 = true
+	}
+}
+
+// WithRoundTripper makes the unary transport send requests through rt, e.g. an HTTP/3 round tripper of quic-go,
+// or a round tripper which adds tracing, retries or a proxy around http.DefaultTransport.
+// It is used by every HTTP request of the client: unary and server streaming APIs, ConnectTransport and Dial.
+// The framing of gRPC Web is the same for any round tripper. WithTLSConfig and WithHTTP2 don't affect rt.
+// Streaming APIs through WebSocket are not affected.
+func WithRoundTripper(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.topts.RoundTripper = rt
+	}
+}
+
+// WithErrorMapper registers a function which converts responses of gateways into errors,
+// for nonstandard backends which report errors differently, e.g. as JSON bodies with a non-200 HTTP status.
+// f receives responses which can't be handled as gRPC Web responses, i.e. with a non-200 HTTP status or an unexpected content-type,
+// and their bodies. Return a status error to report the status of the call.
+// If f returns nil, HTTPStatusError or ContentTypeError is returned as usual. The trailer parsing of gRPC Web responses is not affected.
+func WithErrorMapper(f func(res *http.Response, body []byte) error) ClientOption {
+	return func(c *Client) {
+		c.topts.ErrorMapper = f
+	}
+}
+
+// WithSentBytesCallback registers a callback which receives the framed request body
+// exactly as it is written to the transport, for each request message.
+// It is useful to verify request signing.
+// The callback must not retain or modify the passed bytes.
+func WithSentBytesCallback(f func([]byte)) ClientOption {
+	return func(c *Client) {
+		c.sentBytesCallback = f
+	}
+}
+
+// WithResponseTap registers a callback which receives the raw response body of each unary call before it is parsed.
+// For non-200 HTTP responses, it receives the beginning of the body held by HTTPStatusError.
+// It is useful to diagnose unexpected responses, such as HTML error pages from misconfigured proxies.
+// The whole body is buffered while the tap is registered. With MaxCallRecvMsgSize, a body larger than the limit
+// plus 64KiB for frame headers and the trailer results in a ResourceExhausted error.
+// The callback must not retain or modify the passed bytes.
+func WithResponseTap(f func([]byte)) ClientOption {
+	return func(c *Client) {
+		c.responseTap = f
+	}
+}
+
+// WithFrameHook registers a hook which is called for every frame sent or received by all APIs,
+// including trailer and metadata frames. It is useful to record frame-level traces.
+// The hook must not retain or modify the passed body.
+func WithFrameHook(f func(dir Direction, flag byte, body []byte)) ClientOption {
+	return func(c *Client) {
+		c.frameHook = f
+	}
+}
+
+// WithLogger makes the client write logs to l.
+// By default, logs are discarded.
+func WithLogger(l Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithHTTPClientStreaming makes client streaming APIs send requests through the unary transport (HTTP) instead of WebSocket.
+// Requests are buffered, and sent in one request body as concatenated frames on CloseAndReceive.
+// ClientStream.Flush starts the request earlier, and streams the buffered requests in a chunked request body.
+// It is useful for gateways which accept client streaming over HTTP but not over WebSocket.
+func WithHTTPClientStreaming() ClientOption {
+	return func(c *Client) {
+		c.httpClientStreaming = true
+	}
+}
+
+// WithMaxConcurrentStreams limits the number of streams which are opened through the stream transport at the same time.
+// Opening a stream blocks until another stream ends, or fails with the error of the context if it is done.
+// A stream ends when its transport is finished or closed. (i.e. CloseAndReceive of client streams, and Close of bidi streams)
+// Zero or a negative n means no limit.
+func WithMaxConcurrentStreams(n int) ClientOption {
+	return func(c *Client) {
+		c.streams = nil
+		if n > 0 {
+			c.streams = make(chan struct{}, n)
+		}
+	}
+}
+
+// ReconnectPolicy configures automatic reconnection of server streams.
+type ReconnectPolicy struct {
+	// MaxAttempts is the max number of reconnections per stream.
+	MaxAttempts int
+
+	// Backoff is the duration to wait before each reconnection.
+	Backoff time.Duration
+}
+
+// WithStreamReconnect enables automatic reconnection of server streaming APIs.
+// If the response stream is disconnected before the trailer, the client re-sends the original request
+// up to policy.MaxAttempts times per stream. By default, the stream fails on disconnection.
+// Other errors, such as malformed frames or messages which can't be decompressed, are not retried.
+// The Header CallOption receives the headers of the last connection.
+//
+// The server restarts the stream from the beginning, so the API must tolerate receiving the same request again,
+// and the caller should be prepared for duplicated responses.
+func WithStreamReconnect(policy ReconnectPolicy) ClientOption {
+	return func(c *Client) {
+		c.streamReconnect = policy
+	}
+}
+
+// WithStrictStatus makes the client require the grpc-status in every unary and client streaming response.
+// By default, an empty or absent grpc-status is treated as OK.
+// With this option, a response which has no grpc-status results in an error.
+func WithStrictStatus() ClientOption {
+	return func(c *Client) {
+		c.strictStatus = true
+	}
+}
+
+// WithTrailerValidator registers a validator which inspects the trailer of every unary and client streaming response.
+// It is called only if the response status is OK. If it returns an error, the call fails with the error as it is,
+// so that the validator can return an error created by the status package.
+func WithTrailerValidator(f func(metadata.MD) error) ClientOption {
+	return func(c *Client) {
+		c.trailerValidator = f
+	}
+}
+
+// WithCallTimeout bounds each call by d, as if the context of the call has the timeout.
+// If the context passed to the call has a shorter deadline, it takes precedence.
+// For streaming APIs, the timeout covers the whole stream.
+func WithCallTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.callTimeout = d
+	}
+}
+
+// WithWaitForReady makes unary calls wait for the server to be ready, like wait-for-ready of grpc-go.
+// If the client can't connect to the server, e.g. the connection is refused, the request is retried with backoff
+// until it succeeds or the context of the call is done. Errors which the server responded are not retried.
+// It is useful for clients which are created before the server starts.
+func WithWaitForReady() ClientOption {
+	return func(c *Client) {
+		c.waitForReady = true
+	}
+}
+
+// WithDefaultCallOptions specifies CallOptions which are applied to every call.
+// CallOptions passed to each call are applied after them, so they override the defaults.
+func WithDefaultCallOptions(opts ...CallOption) ClientOption {
+	return func(c *Client) {
+		c.defaultCallOptions = append(c.defaultCallOptions, opts...)
+	}
+}
+
+// PerRPCCredentials provides request metadata which is attached to every request.
+// It corresponds to credentials.PerRPCCredentials of grpc-go.
+type PerRPCCredentials interface {
+	// GetRequestMetadata is called before each request is sent.
+	// The returned map is added to the request headers.
+	GetRequestMetadata(ctx context.Context) (map[string]string, error)
+}
+
+// WithPerRPCCredentials specifies credentials which are used to obtain request metadata for each request.
+// It is useful to refresh short-lived tokens like OAuth2 access tokens.
+// The metadata is attached to requests sent by Unary and ServerStreaming.
+// For ClientStreaming and BidiStreaming, it is sent in the WebSocket handshake and the headers of the stream.
+func WithPerRPCCredentials(creds PerRPCCredentials) ClientOption {
+	return func(c *Client) {
+		c.creds = creds
+	}
+}
+
+// WithContextMetadata makes the client send metadata attached to the context by metadata.NewOutgoingContext
+// as request headers, the same way as grpc-go. It lets existing interceptors which put metadata on the context work unchanged.
+// Like WithPerRPCCredentials, the metadata is attached to requests and streams of all kinds of calls.
+func WithContextMetadata() ClientOption {
+	return func(c *Client) {
+		c.contextMetadata = true
+	}
+}
+
+// WithStreamMetadataCallback registers a callback which receives metadata frames
+// interleaved between messages of server streaming and bidirectional streaming APIs.
+// Such frames are skipped if no callbacks are registered.
+func WithStreamMetadataCallback(f func(metadata.MD)) ClientOption {
+	return func(c *Client) {
+		c.streamMetadataCallback = f
+	}
+}
+
 // Client starts each API session.
 type Client struct {
 	host string
 
-	tb    TransportBuilder
-	stb   StreamTransportBuilder
+	tb    TransportBuilderWithOptions
+	stb   StreamTransportBuilderWithOptions
+	topts TransportOptions
 	codec encoding.Codec
+
+	// compressor compresses request messages. nil means no compression.
+	compressor encoding.Compressor
+	// compressThreshold is the min size of request messages to be compressed.
+	compressThreshold int
+
+	validateRequests bool
+
+	creds           PerRPCCredentials
+	contextMetadata bool
+
+	defaultCallOptions []CallOption
+	callTimeout        time.Duration
+	waitForReady       bool
+	unaryInterceptors  []UnaryInterceptor
+
+	strictStatus        bool
+	trailerValidator    trailerValidator
+	httpClientStreaming bool
+	streamReconnect     ReconnectPolicy
+
+	// streams is a semaphore of WithMaxConcurrentStreams. nil means no limit.
+	streams chan struct{}
+	// active tracks open streams to close them by CloseStreams.
+	active activeStreams
+
+	sentBytesCallback      bytesCallback
+	responseTap            bytesCallback
+	streamMetadataCallback metadataCallback
+	frameHook              frameHook
+
+	logger       Logger
+	statsHandler StatsHandler
 }
 
 // NewClient instantiates new API client for a gRPC Web API server.
@@ -54,183 +517,1018 @@ func NewClient(host string, opts ...ClientOption) *Client {
 	}
 
 	if c.tb == nil {
-		c.tb = DefaultTransportBuilder
+		c.tb = HTTPTransportBuilderWithOptions
 	}
 
 	if c.stb == nil {
-		c.stb = DefaultStreamTransportBuilder
+		c.stb = WebSocketTransportBuilderWithOptions
 	}
 
+	// all unary requests share the same HTTP client to reuse connections.
+	c.topts.HTTPClient = newHTTPClient(&c.topts)
+
 	if c.codec == nil {
 		// use Protocol Buffers as a default codec.
 		c.codec = encoding.GetCodec(pb.Name)
 	}
 
+	if c.topts.ContentType == "" {
+		c.topts.ContentType = "application/grpc-web+" + c.codec.Name()
+	}
+
+	if c.validateRequests {
+		c.codec = validatingCodec{c.codec}
+	}
+
+	if c.logger == nil {
+		c.logger = nopLogger{}
+	} else {
+		c.frameHook = c.frameHook.withLogger(c.logger)
+	}
+
 	return c
 }
 
+// Dial instantiates new API client like NewClient, and verifies that the server is reachable by a HEAD request.
+// Any HTTP response means the server is reachable, so Dial fails only if the connection can't be established.
+// (e.g. DNS resolution, connection refused or TLS errors)
+// It is useful to fail fast at startup when the host is misconfigured. ctx bounds the check.
+func Dial(ctx context.Context, host string, opts ...ClientOption) (*Client, error) {
+	c := NewClient(host, opts...)
+	if err := c.ping(ctx); err != nil {
+		c.Close()
+		return nil, errors.Wrapf(err, "failed to connect to %s", host)
+	}
+	return c, nil
+}
+
+// ping sends a HEAD request to the base path of the server through the HTTP client shared by unary transports.
+func (c *Client) ping(ctx context.Context) error {
+	protocol := "https"
+	if c.topts.Insecure {
+		protocol = "http"
+	}
+	req, err := http.NewRequest(http.MethodHead, fmt.Sprintf("%s://%s%s", protocol, c.host, c.topts.path("/")), nil)
+	if err != nil {
+		return err
+	}
+	if c.topts.Authority != "" {
+		req.Host = c.topts.Authority
+	}
+	res, err := c.topts.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Close closes all open streams by CloseStreams, and releases resources pooled by the client, such as idle connections.
+// After Close, the client must not be reused.
+func (c *Client) Close() error {
+	c.CloseStreams()
+	if t, ok := c.topts.HTTPClient.Transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+// CloseStreams cancels all server streams and closes all stream transports which are opened by the client and not ended yet.
+// Receive and Send of the closed streams fail. It is useful for graceful shutdown.
+func (c *Client) CloseStreams() {
+	c.active.closeAll()
+}
+
+// withCallTimeout returns a copy of ctx which is canceled by the returned function or after the call timeout.
+func (c *Client) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.callTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	// context.WithTimeout keeps the deadline of ctx if it is shorter.
+	return context.WithTimeout(ctx, c.callTimeout)
+}
+
+// withRequestMetadata attaches the outgoing metadata of ctx (if WithContextMetadata is specified)
+// and request metadata provided by per-RPC credentials to ctx.
+func (c *Client) withRequestMetadata(ctx context.Context) (context.Context, error) {
+	if c.contextMetadata {
+		if md, ok := metadata.FromOutgoingContext(ctx); ok {
+			ctx = withHeader(ctx, md)
+		}
+	}
+	if c.creds == nil {
+		return ctx, nil
+	}
+	m, err := c.creds.GetRequestMetadata(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get request metadata from the credentials")
+	}
+	return withHeader(ctx, metadata.New(m)), nil
+}
+
 // Unary sends an unary request. (also known as simple request)
-func (c *Client) Unary(ctx context.Context, req *Request) (*Response, error) {
-	r, err := parseRequestBody(c.codec, req.in)
+// The call goes through the interceptors registered by WithChainUnaryInterceptor.
+func (c *Client) Unary(ctx context.Context, req *Request, opts ...CallOption) (*Response, error) {
+	if len(c.unaryInterceptors) == 0 {
+		return c.unary(ctx, req, opts...)
+	}
+	return chainUnaryInterceptors(c.unaryInterceptors, c.unary)(ctx, req, opts...)
+}
+
+func (c *Client) unary(ctx context.Context, req *Request, opts ...CallOption) (_ *Response, err error) {
+	if err := req.checkKind(false, false); err != nil {
+		return nil, err
+	}
+	ci := c.newCallInfo(opts)
+	req = ci.request(req)
+	stats := c.startCall(req.endpoint)
+	defer func() {
+		err = contextError(err)
+		stats.end(err)
+	}()
+
+	h, msg, err := marshalRequest(c.codec, c.compressor, c.compressThreshold, req.in)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to build the request body")
 	}
 
-	rawBody, err := c.tb(c.host, req).Send(ctx, r)
+	// the buffer is returned to the pool after the call, once the transport closes the body.
+	var pb *pooledBody
+	if len(msg) < maxPooledBufferSize {
+		r := framedBuffer(h, msg)
+		c.sentBytesCallback.call(r)
+		c.frameHook.outbound(r.Bytes())
+		pb = newPooledBody(r)
+	} else {
+		// large messages are streamed after the header, without copying them into a framed buffer.
+		if c.sentBytesCallback != nil {
+			c.sentBytesCallback(append(h, msg...))
+		}
+		if c.frameHook != nil {
+			c.frameHook(Outbound, h[0], msg)
+		}
+		pb = newFrameBody(h, msg)
+	}
+	defer pb.unref()
+
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	return c.sendUnary(ctx, req, func() io.Reader { return pb.reader() }, ci)
+}
+
+// newCallInfo applies the default CallOptions and opts in order.
+func (c *Client) newCallInfo(opts []CallOption) *callInfo {
+	return newCallInfo(append(append([]CallOption(nil), c.defaultCallOptions...), opts...))
+}
+
+// sendUnary sends framed requests in the body returned by newBody through the unary transport, and receives the response into req.out.
+// newBody is called for each attempt of the request.
+func (c *Client) sendUnary(ctx context.Context, req *Request, newBody func() io.Reader, ci *callInfo) (*Response, error) {
+	ctx, err := c.withRequestMetadata(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to send the request")
+		return nil, err
 	}
-	defer rawBody.Close()
+	ctx = ci.withTrace(ctx)
 
-	resBody, err := parseResponseBody(rawBody)
+	t, rawBody, err := c.send(ctx, req, newBody)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to build the response body")
+		c.logger.Errorf("grpcweb: failed to send a request to %s: %s", req.endpoint, err)
+		switch e := errors.Cause(err).(type) {
+		case *HTTPStatusError:
+			c.responseTap.call(bytes.NewBuffer(e.Body))
+		case *ContentTypeError:
+			c.responseTap.call(bytes.NewBuffer(e.Body))
+		}
+		return nil, wrapError(err, "failed to send the request")
+	}
+	defer drainBody(rawBody)
+
+	var r io.Reader = bufio.NewReader(rawBody)
+	if c.responseTap != nil {
+		// the tap buffers the whole body, so bound it by MaxCallRecvMsgSize as the frame reader does.
+		var body io.Reader = rawBody
+		limit := int64(ci.maxRecvMsgSize)
+		if limit > 0 {
+			limit += maxTapOverhead
+			body = io.LimitReader(rawBody, limit+1)
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the response body")
+		}
+		if limit > 0 && int64(len(b)) > limit {
+			return nil, status.Errorf(codes.ResourceExhausted, "received response body larger than max (%d)", limit)
+		}
+		c.responseTap(b)
+		r = bytes.NewReader(b)
 	}
 
-	if err := c.codec.Unmarshal(resBody, req.out); err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal response body by codec %s", c.codec.Name())
+	ci.setHeader(t.Header())
+	comp, err := responseCompressor(t.Header(), c.compressor)
+	if err != nil {
+		return nil, err
+	}
+	fr := frameReader{hook: c.frameHook, limit: ci.maxRecvMsgSize, compressor: comp, onRead: ci.received}
+	trailer, trailersOnly, err := receiveUnaryResponse(r, t.Header(), c.codec, req.out, c.strictStatus, c.trailerValidator, fr)
+	ci.setTrailer(trailer)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Response{
-		ContentType: c.codec.Name(),
-		Content:     req.out,
+		ContentType:  c.codec.Name(),
+		Content:      req.out,
+		TrailersOnly: trailersOnly,
 	}, nil
 }
 
+// minWaitForReadyBackoff and maxWaitForReadyBackoff bound the backoff between attempts of WithWaitForReady.
+const (
+	minWaitForReadyBackoff = 100 * time.Millisecond
+	maxWaitForReadyBackoff = 5 * time.Second
+)
+
+// send sends the body through a new unary transport.
+// If WithWaitForReady is specified, it retries connection failures until ctx is done.
+func (c *Client) send(ctx context.Context, req *Request, newBody func() io.Reader) (Transport, io.ReadCloser, error) {
+	backoff := minWaitForReadyBackoff
+	for {
+		c.logger.Debugf("grpcweb: sending a request to %s", req.endpoint)
+		t := c.tb(c.host, req, &c.topts)
+		rawBody, err := t.Send(ctx, newBody())
+		if err == nil || !c.waitForReady || !isConnectionError(err) {
+			return t, rawBody, err
+		}
+
+		c.logger.Debugf("grpcweb: the server is not ready, retrying %s in %s: %s", req.endpoint, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, nil, errors.Wrapf(ctx.Err(), "the server is not ready (%s)", err)
+		}
+		if backoff *= 2; backoff > maxWaitForReadyBackoff {
+			backoff = maxWaitForReadyBackoff
+		}
+	}
+}
+
+// isConnectionError reports whether err is a failure to connect to the server, such as connection refused or DNS errors.
+// Requests which fail by it are not sent to the server.
+func isConnectionError(err error) bool {
+	e, ok := errors.Cause(err).(*url.Error)
+	if !ok {
+		return false
+	}
+	oe, ok := e.Err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
+
+// receiveUnaryResponse reads a message frame and the trailer frame from r.
+// The message is unmarshaled into out, and the status in the trailer is returned as an error.
+// If the status is OK, the trailer is validated by validator.
+// The trailer is returned if it is received, even if the status is not OK.
+// It also reports whether the response is trailers-only, which has no message frames.
+// In that case, the status is sent in the trailer frame or, if the body is empty, in the response headers.
+func receiveUnaryResponse(r io.Reader, header metadata.MD, codec encoding.Codec, out interface{}, strictStatus bool, validator trailerValidator, fr frameReader) (metadata.MD, bool, error) {
+	var trailer metadata.MD
+	flag, resBody, err := fr.readFrame(r)
+	switch {
+	case err == io.EOF && hasStatus(header):
+		trailer = header
+	case err != nil:
+		return nil, false, wrapError(err, "failed to build the response body")
+	case flag&flagTrailer != 0:
+		trailer = parseTrailer(resBody)
+	default:
+		if err := unmarshalMessage(codec, resBody, out); err != nil {
+			return nil, false, errors.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
+		}
+
+		_, trailerBody, err := fr.readFrame(r)
+		if err == io.EOF {
+			trailerBody = nil
+		} else if err != nil {
+			return nil, false, errors.Wrap(err, "failed to read the trailer")
+		}
+		trailer = parseTrailer(trailerBody)
+	}
+
+	trailersOnly := err != nil || flag&flagTrailer != 0
+	if err := statusFromTrailer(trailer, strictStatus); err != nil {
+		return trailer, trailersOnly, err
+	}
+	return trailer, trailersOnly, validator.validate(trailer)
+}
+
 type ServerStreamClient interface {
+	// Receive receives a response.
+	// At the end of the stream, Receive returns exactly io.EOF, so callers can compare it by ==.
+	// If the stream ends with a non-OK status, the status error is returned instead.
+	// Once the stream ends, subsequent calls return the same error.
 	Receive() (*Response, error)
 }
 
+// ServerStream is implemented by ServerStreamClients returned by Client.ServerStreaming.
+// It is separated from ServerStreamClient so that existing implementations of ServerStreamClient keep compiling.
+// Use a type assertion to call its methods:
+//
+//   stream.(grpcweb.ServerStream).Cancel()
+type ServerStream interface {
+	ServerStreamClient
+
+	// Cancel stops receiving responses and tells the server to stop sending by closing the underlying transport.
+	// Receive returns an error with codes.Canceled after Cancel is called.
+	Cancel()
+
+	// ReceiveChan spawns a goroutine which calls Receive in a loop, and returns channels which deliver the results.
+	// Both channels are closed at the end of the stream. A non-EOF error is sent to the error channel before closing.
+	// The goroutine exits when the context passed to ServerStreaming is done.
+	// Receive must not be called after ReceiveChan.
+	ReceiveChan() (<-chan *Response, <-chan error)
+
+	// The following methods have the same shape as grpc.ClientStream, to abstract over both clients.
+
+	// Recv is like Receive, but returns the received message which is the output message of the request.
+	Recv() (proto.Message, error)
+	// Context returns the context of the stream, which is done when the stream is canceled.
+	Context() context.Context
+	// Header returns the response headers. They are always received before ServerStreaming returns.
+	Header() (metadata.MD, error)
+	// Trailer returns the trailer. It is populated once Receive or Recv returns the end of the stream.
+	Trailer() metadata.MD
+}
+
 type serverStreamClient struct {
-	ctx context.Context
-	t   Transport
-	req *Request
+	ctx    context.Context
+	cancel context.CancelFunc
+	t      Transport
+	req    *Request
 
+	// m guards resStream which is replaced by reconnection.
+	m         sync.Mutex
 	resStream io.ReadCloser
 
-	codec encoding.Codec
+	// body is the original request, which is returned to the pool once by release.
+	body        *pooledBody
+	releaseOnce sync.Once
+
+	// send sends the original request through a new transport.
+	send      func() (Transport, io.ReadCloser, error)
+	reconnect ReconnectPolicy
+	attempts  int
+
+	// maxRecvMsgSize is the max size of a received message. Zero means no limit.
+	maxRecvMsgSize int
+	ci             *callInfo
+
+	// end is the error which ended the stream. It is io.EOF or a status error.
+	end     error
+	trailer metadata.MD
+
+	stats  *callStats
+	active *activeStreams
+
+	codec      encoding.Codec
+	compressor encoding.Compressor
+	logger     Logger
+
+	sentBytesCallback      bytesCallback
+	streamMetadataCallback metadataCallback
+	frameHook              frameHook
+}
+
+// Receive receives multi responses through a stream.
+// Receive returns io.EOF at the end.
+func (c *serverStreamClient) Receive() (*Response, error) {
+	res, err := c.receive()
+	if err != nil {
+		err = contextError(err)
+		c.stats.end(err)
+		c.active.remove(c)
+	}
+	if c.end != nil {
+		// the stream has ended, so release the connection and the timer of the call timeout.
+		c.release()
+	}
+	return res, err
+}
+
+func (c *serverStreamClient) receive() (*Response, error) {
+	if c.end != nil {
+		return nil, c.end
+	}
+
+	c.m.Lock()
+	t, resStream := c.t, c.resStream
+	c.m.Unlock()
+
+	comp, err := responseCompressor(t.Header(), c.compressor)
+	if err != nil {
+		return nil, err
+	}
+
+	flag, resBody, err := frameReader{hook: c.frameHook, limit: c.maxRecvMsgSize, compressor: comp, onRead: c.ci.received}.readFrame(resStream)
+	if cerr := c.ctx.Err(); cerr != nil {
+		return nil, cerr
+	}
+	if err == io.EOF {
+		c.end = io.EOF
+		// the status is sent in the headers if the response is trailers-only and the body is empty.
+		if h := t.Header(); hasStatus(h) {
+			c.trailer = h
+			c.ci.setTrailer(h)
+			if err := statusFromTrailer(h, false); err != nil {
+				c.end = err
+			}
+		}
+		return nil, c.end
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		return nil, err
+	}
+
+	if err != nil {
+		c.logger.Errorf("grpcweb: failed to read a frame from %s: %s", c.req.endpoint, err)
+		// malformed frames and decompression failures are bugs of the server, which reconnecting doesn't fix.
+		if isDisconnection(err) && c.attempts < c.reconnect.MaxAttempts {
+			if rerr := c.reconnectStream(); rerr == nil {
+				return c.receive()
+			}
+		}
+		return nil, errors.Wrap(err, "failed to build the response body")
+	}
+
+	if c.streamMetadataCallback.handle(flag, resBody) {
+		return c.receive()
+	}
+
+	if flag&flagTrailer != 0 {
+		c.trailer = parseTrailer(resBody)
+		c.ci.setTrailer(c.trailer)
+		c.end = endOfStream(resBody)
+		return nil, c.end
+	}
+
+	if err := unmarshalMessage(c.codec, resBody, c.req.out); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal response body")
+	}
+
+	return &Response{
+		ContentType: c.codec.Name(),
+		Content:     c.req.out,
+	}, nil
+}
+
+func (c *serverStreamClient) Recv() (proto.Message, error) {
+	res, err := c.Receive()
+	if err != nil {
+		return nil, err
+	}
+	m, ok := res.Content.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("the output type %T is not a proto.Message", res.Content)
+	}
+	return m, nil
+}
+
+func (c *serverStreamClient) Context() context.Context {
+	return c.ctx
+}
+
+func (c *serverStreamClient) Header() (metadata.MD, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.t.Header(), nil
+}
+
+func (c *serverStreamClient) Trailer() metadata.MD {
+	return c.trailer
+}
+
+// reconnectStream re-sends the original request until it succeeds or the attempts are exhausted.
+func (c *serverStreamClient) reconnectStream() error {
+	var err error
+	for c.attempts < c.reconnect.MaxAttempts {
+		c.attempts++
+		c.logger.Debugf("grpcweb: reconnecting to %s in %s (attempt %d/%d)", c.req.endpoint, c.reconnect.Backoff, c.attempts, c.reconnect.MaxAttempts)
+		select {
+		case <-time.After(c.reconnect.Backoff):
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+
+		var (
+			t         Transport
+			resStream io.ReadCloser
+		)
+		t, resStream, err = c.send()
+		if err != nil {
+			c.logger.Errorf("grpcweb: failed to reconnect to %s: %s", c.req.endpoint, err)
+			continue
+		}
+
+		c.m.Lock()
+		c.resStream.Close()
+		c.t, c.resStream = t, resStream
+		c.m.Unlock()
+		c.ci.setHeader(t.Header())
+		return nil
+	}
+	return err
+}
+
+// isDisconnection reports whether err of reading a response stream is caused by losing the connection.
+func isDisconnection(err error) bool {
+	cause := errors.Cause(err)
+	if status.Code(cause) == codes.Unavailable {
+		return true
+	}
+	if _, ok := cause.(net.Error); ok {
+		return true
+	}
+	return cause == io.ErrUnexpectedEOF || cause == ErrConnectionClosed
+}
+
+// Cancel cancels the request and closes the response body.
+func (c *serverStreamClient) Cancel() {
+	c.stats.end(context.Canceled)
+	c.active.remove(c)
+	c.release()
+}
+
+// release cancels the context of the request and closes the response body.
+func (c *serverStreamClient) release() {
+	c.cancel()
+	c.m.Lock()
+	c.resStream.Close()
+	c.m.Unlock()
+	c.releaseOnce.Do(c.body.unref)
+}
+
+func (c *serverStreamClient) closeStream() {
+	c.Cancel()
+}
+
+func (c *serverStreamClient) ReceiveChan() (<-chan *Response, <-chan error) {
+	resc, errc := make(chan *Response), make(chan error, 1)
+	go func() {
+		defer close(resc)
+		defer close(errc)
+		for {
+			res, err := c.Receive()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			// Receive reuses the same message, so pass a copy to the receiver.
+			if m, ok := res.Content.(proto.Message); ok {
+				res.Content = proto.Clone(m)
+			}
+
+			select {
+			case resc <- res:
+			case <-c.ctx.Done():
+				errc <- contextError(c.ctx.Err())
+				return
+			}
+		}
+	}()
+	return resc, errc
+}
+
+// ServerStreamClient sends only one request and receives multi responses through a stream.
+// The returned ServerStreamClient also implements ServerStream.
+func (c *Client) ServerStreaming(ctx context.Context, req *Request, opts ...CallOption) (ServerStreamClient, error) {
+	if err := req.checkKind(false, true); err != nil {
+		return nil, err
+	}
+
+	r, err := parseRequestBody(c.codec, c.compressor, c.compressThreshold, req.in)
+	if err != nil {
+		return nil, err
+	}
+	c.sentBytesCallback.call(r)
+	c.frameHook.outbound(r.Bytes())
+	// the body is kept for reconnection, and returned to the pool when the stream is released.
+	body := newPooledBody(r)
+
+	ctx, err = c.withRequestMetadata(ctx)
+	if err != nil {
+		body.unref()
+		return nil, err
+	}
+	ci := c.newCallInfo(opts)
+	ctx = ci.withTrace(ctx)
+	req = ci.request(req)
+
+	stats := c.startCall(req.endpoint)
+
+	// the request is canceled by Cancel.
+	ctx, cancel := c.withCallTimeout(ctx)
+	send := func() (Transport, io.ReadCloser, error) {
+		c.logger.Debugf("grpcweb: sending a request to %s", req.endpoint)
+		t := c.tb(c.host, req, &c.topts)
+		resStream, err := t.Send(ctx, body.reader())
+		if err != nil {
+			return t, nil, err
+		}
+		return t, newBufferedBody(resStream), nil
+	}
+	t, resStream, err := send()
+	if err != nil {
+		c.logger.Errorf("grpcweb: failed to send a request to %s: %s", req.endpoint, err)
+		err = contextError(err)
+		stats.end(err)
+		cancel()
+		body.unref()
+		return nil, err
+	}
+	ci.setHeader(t.Header())
+
+	sc := &serverStreamClient{
+		ctx:       ctx,
+		cancel:    cancel,
+		t:         t,
+		req:       req,
+		resStream: resStream,
+		send:      send,
+		body:      body,
+		reconnect: c.streamReconnect,
+		codec:     c.codec,
+		logger:    c.logger,
+
+		compressor: c.compressor,
+
+		maxRecvMsgSize: ci.maxRecvMsgSize,
+		ci:             ci,
+		stats:          stats,
+		active:         &c.active,
+
+		sentBytesCallback:      c.sentBytesCallback,
+		streamMetadataCallback: c.streamMetadataCallback,
+		frameHook:              c.frameHook,
+	}
+	c.active.add(sc)
+	return sc, nil
+}
+
+// ClientStreamClient sends multi requests and receives only one response.
+// At the end, ClientStreamClient must be call CloseAndReceive method.
+type ClientStreamClient interface {
+	Send(*Request) error
+	CloseAndReceive() (*Response, error)
+}
+
+// ClientStream is implemented by ClientStreamClients returned by Client.ClientStreaming.
+// It is separated from ClientStreamClient so that existing implementations of ClientStreamClient keep compiling.
+// Use a type assertion to call its methods:
+//
+//   stream.(grpcweb.ClientStream).Flush()
+type ClientStream interface {
+	ClientStreamClient
+
+	// Flush writes the requests which are buffered by the client to the server immediately.
+	// With WithHTTPClientStreaming, the first Flush starts the request, and its body is streamed from then on.
+	// Through the stream transport, Send writes each request immediately, so there is nothing to flush.
+	// It fails with codes.Unimplemented for grpc-web-text, which sends the whole request body at once.
+	Flush() error
+}
+
+type clientStreamClient struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	reqOnce sync.Once
+
+	// curried StreamTransportBuilder
+	stb func(req *Request) (StreamTransport, error)
+	t   StreamTransport
+	req *Request
+
+	codec             encoding.Codec
+	compressor        encoding.Compressor
+	compressThreshold int
+
+	strictStatus     bool
+	trailerValidator trailerValidator
+
+	// startCall reports the start of the call on the first Send.
+	startCall func(endpoint string) *callStats
+	stats     *callStats
+
+	sentBytesCallback bytesCallback
+	frameHook         frameHook
+}
+
+func (c *clientStreamClient) Send(req *Request) error {
+	if err := req.checkKind(true, false); err != nil {
+		return err
+	}
+
+	var err error
+	c.reqOnce.Do(func() {
+		c.stats = c.startCall(req.endpoint)
+		c.t, err = c.stb(req)
+		c.req = req
+		if err != nil {
+			err = contextError(err)
+			c.stats.end(err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	r, err := parseRequestBody(c.codec, c.compressor, c.compressThreshold, req.in)
+	if err != nil {
+		return err
+	}
+	c.sentBytesCallback.call(r)
+	c.frameHook.outbound(r.Bytes())
+
+	// StreamTransport doesn't retain the body after Send returns.
+	defer releaseBuffer(r)
+	return contextError(c.t.Send(r))
+}
+
+// Flush does nothing because Send writes each request to the stream transport immediately.
+func (c *clientStreamClient) Flush() error {
+	return nil
+}
+
+func (c *clientStreamClient) CloseAndReceive() (_ *Response, err error) {
+	defer c.cancel()
+	defer func() {
+		err = contextError(err)
+		c.stats.end(err)
+	}()
+
+	res, err := c.t.Finish()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	_, trailersOnly, err := receiveUnaryResponse(res, nil, c.codec, c.req.out, c.strictStatus, c.trailerValidator, frameReader{hook: c.frameHook, compressor: c.compressor})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		ContentType:  c.codec.Name(),
+		Content:      c.req.out,
+		TrailersOnly: trailersOnly,
+	}, nil
+}
+
+// httpClientStreamClient buffers requests, and sends them in one request body through the unary transport on CloseAndReceive.
+type httpClientStreamClient struct {
+	ctx    context.Context
+	client *Client
+
+	req *Request
+	// body buffers requests until CloseAndReceive or Flush.
+	body  bytes.Buffer
+	stats *callStats
+
+	// pw is the request body of the call started by the first Flush. It is nil before that.
+	pw     *io.PipeWriter
+	resc   chan unaryResult
+	cancel context.CancelFunc
+}
+
+// unaryResult is the result of a unary call which runs in another goroutine.
+type unaryResult struct {
+	res *Response
+	err error
+}
+
+func (c *httpClientStreamClient) Send(req *Request) error {
+	if err := req.checkKind(true, false); err != nil {
+		return err
+	}
+	if c.req == nil {
+		c.req = req
+		c.stats = c.client.startCall(req.endpoint)
+	}
+
+	r, err := parseRequestBody(c.client.codec, c.client.compressor, c.client.compressThreshold, req.in)
+	if err != nil {
+		return err
+	}
+	c.client.sentBytesCallback.call(r)
+	c.client.frameHook.outbound(r.Bytes())
+
+	_, err = r.WriteTo(&c.body)
+	releaseBuffer(r)
+	return err
+}
+
+func (c *httpClientStreamClient) Flush() error {
+	if c.req == nil {
+		return nil
+	}
+	if isTextContentType(c.client.topts.contentType()) {
+		return status.Error(codes.Unimplemented, "requests can't be flushed with grpc-web-text, which sends the whole request body at once")
+	}
+	if c.pw == nil {
+		c.start()
+	}
+	_, err := c.pw.Write(c.body.Bytes())
+	c.body.Reset()
+	return contextError(err)
+}
+
+// start starts the call whose request body is streamed through c.pw.
+func (c *httpClientStreamClient) start() {
+	pr, pw := io.Pipe()
+	ctx, cancel := c.client.withCallTimeout(c.ctx)
+	c.pw, c.resc, c.cancel = pw, make(chan unaryResult, 1), cancel
+
+	// the length of the body is unknown, so it is sent in chunks.
+	// pr is hidden behind io.Reader, otherwise the transport closes it when a retry of WithWaitForReady is needed.
+	body := struct{ io.Reader }{pr}
+	go func() {
+		res, err := c.client.sendUnary(ctx, c.req, func() io.Reader { return body }, c.client.newCallInfo(nil))
+		// unblock writes to the body if the call ends before the body is written.
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.CloseWithError(io.ErrClosedPipe)
+		}
+		c.resc <- unaryResult{res: res, err: err}
+	}()
 }
 
-// Receive receives multi responses through a stream.
-// Receive returns io.EOF at the end.
-func (c *serverStreamClient) Receive() (*Response, error) {
-	resBody, err := parseResponseBody(c.resStream)
-	if err == io.EOF {
-		return nil, err
+func (c *httpClientStreamClient) CloseAndReceive() (*Response, error) {
+	if c.req == nil {
+		return nil, errors.New("CloseAndReceive must be called after Send")
 	}
+	if c.pw == nil {
+		ctx, cancel := c.client.withCallTimeout(c.ctx)
+		defer cancel()
+		res, err := c.client.sendUnary(ctx, c.req, func() io.Reader { return bytes.NewReader(c.body.Bytes()) }, c.client.newCallInfo(nil))
+		err = contextError(err)
+		c.stats.end(err)
+		return res, err
+	}
+
+	defer c.cancel()
+	_, werr := c.pw.Write(c.body.Bytes())
+	c.body.Reset()
+	c.pw.Close()
 
+	// the result of the call has the cause of a write error.
+	r := <-c.resc
+	err := r.err
+	if err == nil && werr != nil {
+		err = errors.Wrap(werr, "failed to write the request body")
+	}
+	err = contextError(err)
+	c.stats.end(err)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to build the response body")
+		return nil, err
 	}
+	return r.res, nil
+}
 
-	// check compressed flag.
-	// compressed flag is 0 or 1.
-	if resBody[0]>>3 != 0 && resBody[0]>>3 != 1 {
-		return nil, io.EOF
+// ClientStreamClient sends multi requests and receives only one response.
+// If WithHTTPClientStreaming is specified, requests are sent through the unary transport instead of the stream transport.
+// The returned ClientStreamClient also implements ClientStream.
+func (c *Client) ClientStreaming(ctx context.Context) (ClientStreamClient, error) {
+	if c.httpClientStreaming {
+		return &httpClientStreamClient{ctx: ctx, client: c}, nil
 	}
 
-	if err := c.codec.Unmarshal(resBody, c.req.out); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal response body")
-	}
+	ctx, cancel := c.withCallTimeout(ctx)
+	return &clientStreamClient{
+		ctx:    ctx,
+		cancel: cancel,
+		stb: func(req *Request) (StreamTransport, error) {
+			return c.openStream(ctx, req.endpoint)
+		},
+		codec:             c.codec,
+		compressor:        c.compressor,
+		compressThreshold: c.compressThreshold,
 
-	return &Response{
-		ContentType: c.codec.Name(),
-		Content:     c.req.out,
+		strictStatus:     c.strictStatus,
+		trailerValidator: c.trailerValidator,
+
+		startCall: c.startCall,
+
+		sentBytesCallback: c.sentBytesCallback,
+		frameHook:         c.frameHook,
 	}, nil
 }
 
-// ServerStreamClient sends only one request and receives multi responses through a stream.
-func (c *Client) ServerStreaming(ctx context.Context, req *Request) (ServerStreamClient, error) {
-	t := c.tb(c.host, req)
+// openStream opens a stream transport to endpoint.
+func (c *Client) openStream(ctx context.Context, endpoint string) (StreamTransport, error) {
+	if c.streams != nil {
+		select {
+		case c.streams <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 
-	r, err := parseRequestBody(c.codec, req.in)
+	ctx, err := c.withRequestMetadata(ctx)
 	if err != nil {
+		c.releaseStream()
 		return nil, err
 	}
 
-	resStream, err := t.Send(ctx, r)
+	c.logger.Debugf("grpcweb: opening a stream to %s", endpoint)
+	t, err := c.stb(ctx, c.host, endpoint, &c.topts)
 	if err != nil {
+		c.logger.Errorf("grpcweb: failed to open a stream to %s: %s", endpoint, err)
+		c.releaseStream()
 		return nil, err
 	}
-
-	return &serverStreamClient{
-		ctx:       ctx,
-		t:         t,
-		req:       req,
-		resStream: resStream,
-		codec:     c.codec,
-	}, nil
+	ot := &openStreamTransport{StreamTransport: t}
+	ot.release = func() {
+		c.active.remove(ot)
+		c.releaseStream()
+	}
+	c.active.add(ot)
+	return ot, nil
 }
 
-// ClientStreamClient sends multi requests and receives only one response.
-// At the end, ClientStreamClient must be call CloseAndReceive method.
-type ClientStreamClient interface {
-	Send(*Request) error
-	CloseAndReceive() (*Response, error)
+// releaseStream releases a slot of WithMaxConcurrentStreams.
+func (c *Client) releaseStream() {
+	if c.streams != nil {
+		<-c.streams
+	}
 }
 
-type clientStreamClient struct {
-	ctx context.Context
+// openStreamTransport releases a slot of WithMaxConcurrentStreams and stops being tracked by CloseStreams when the stream ends.
+type openStreamTransport struct {
+	StreamTransport
 
-	reqOnce sync.Once
+	once    sync.Once
+	release func()
+}
 
-	// curried StreamTransportBuilder
-	stb func(req *Request) (StreamTransport, error)
-	t   StreamTransport
-	req *Request
+func (t *openStreamTransport) Finish() (io.ReadCloser, error) {
+	defer t.once.Do(t.release)
+	return t.StreamTransport.Finish()
+}
 
-	codec encoding.Codec
+func (t *openStreamTransport) Close() error {
+	defer t.once.Do(t.release)
+	return t.StreamTransport.Close()
 }
 
-func (c *clientStreamClient) Send(req *Request) error {
-	var err error
-	c.reqOnce.Do(func() {
-		c.t, err = c.stb(req)
-		c.req = req
-	})
-	if err != nil {
-		return err
-	}
+func (t *openStreamTransport) CloseSend() error {
+	return closeSend(t.StreamTransport)
+}
 
-	r, err := parseRequestBody(c.codec, req.in)
-	if err != nil {
-		return err
-	}
+func (t *openStreamTransport) Ping(ctx context.Context) error {
+	return ping(ctx, t.StreamTransport)
+}
 
-	return c.t.Send(r)
+func (t *openStreamTransport) closeStream() {
+	t.Close()
 }
 
-func (c *clientStreamClient) CloseAndReceive() (*Response, error) {
-	res, err := c.t.Finish()
-	if err != nil {
-		return nil, err
-	}
-	defer res.Close()
+// activeStream is an open stream which is closed by Client.CloseStreams.
+type activeStream interface {
+	closeStream()
+}
 
-	resBody, err := parseResponseBody(res)
-	if err != nil {
-		return nil, err
-	}
+// activeStreams tracks open streams of a client.
+type activeStreams struct {
+	m       sync.Mutex
+	streams map[activeStream]struct{}
+}
 
-	if err := c.codec.Unmarshal(resBody, c.req.out); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal response body")
+func (s *activeStreams) add(st activeStream) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.streams == nil {
+		s.streams = map[activeStream]struct{}{}
 	}
+	s.streams[st] = struct{}{}
+}
 
-	return &Response{
-		ContentType: c.codec.Name(),
-		Content:     c.req.out,
-	}, nil
+func (s *activeStreams) remove(st activeStream) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.streams, st)
 }
 
-// ClientStreamClient sends multi requests and receives only one response.
-func (c *Client) ClientStreaming(ctx context.Context) (ClientStreamClient, error) {
-	return &clientStreamClient{
-		ctx: ctx,
-		stb: func(req *Request) (StreamTransport, error) {
-			return c.stb(c.host, req.endpoint)
-		},
-		codec: c.codec,
-	}, nil
+// closeAll closes all tracked streams. Streams are closed without holding the lock because closing removes them.
+func (s *activeStreams) closeAll() {
+	s.m.Lock()
+	streams := s.streams
+	s.streams = nil
+	s.m.Unlock()
+
+	for st := range streams {
+		st.closeStream()
+	}
 }
 
 // BidiStreamClient sends multi requests and receives multi responses.
@@ -241,37 +1539,92 @@ type BidiStreamClient interface {
 	Close() error
 }
 
+// BidiStream is implemented by BidiStreamClients returned by Client.BidiStreaming.
+// It is separated from BidiStreamClient so that existing implementations of BidiStreamClient keep compiling.
+// Use a type assertion to call its methods:
+//
+//   stream.(grpcweb.BidiStream).CloseSend()
+type BidiStream interface {
+	BidiStreamClient
+
+	// CloseSend tells the server that the client finished sending requests, without closing the stream.
+	// Receive keeps receiving the remaining responses, and returns io.EOF at the end of the stream.
+	// It fails with codes.Unimplemented if the stream transport doesn't implement StreamTransportCloseSender.
+	CloseSend() error
+
+	// Ping checks that the stream connection is alive before ctx is done.
+	// It fails with codes.Unimplemented if the stream transport doesn't implement StreamTransportPinger.
+	Ping(ctx context.Context) error
+}
+
 type bidiStreamClient struct {
-	ctx context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	t StreamTransport
 
-	req *Request
+	req   *Request
+	stats *callStats
 
-	codec encoding.Codec
+	codec             encoding.Codec
+	compressor        encoding.Compressor
+	compressThreshold int
+
+	sentBytesCallback      bytesCallback
+	streamMetadataCallback metadataCallback
+	frameHook              frameHook
 }
 
 func (c *bidiStreamClient) Send(req *Request) error {
-	r, err := parseRequestBody(c.codec, req.in)
+	r, err := parseRequestBody(c.codec, c.compressor, c.compressThreshold, req.in)
 	if err != nil {
 		return err
 	}
+	c.sentBytesCallback.call(r)
+	c.frameHook.outbound(r.Bytes())
+
+	// StreamTransport doesn't retain the body after Send returns.
+	defer releaseBuffer(r)
+	return contextError(c.t.Send(r))
+}
+
+func (c *bidiStreamClient) CloseSend() error {
+	return closeSend(c.t)
+}
 
-	return c.t.Send(r)
+func (c *bidiStreamClient) Ping(ctx context.Context) error {
+	return contextError(ping(ctx, c.t))
 }
 
 func (c *bidiStreamClient) Receive() (*Response, error) {
+	res, err := c.receive()
+	if err != nil {
+		err = contextError(err)
+		c.stats.end(err)
+	}
+	return res, err
+}
+
+func (c *bidiStreamClient) receive() (*Response, error) {
 	res, err := c.t.Receive()
 	if err != nil {
 		return nil, err
 	}
 
-	resBody, err := parseResponseBody(res)
+	flag, resBody, err := frameReader{hook: c.frameHook, compressor: c.compressor}.readFrame(res)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := c.codec.Unmarshal(resBody, c.req.out); err != nil {
+	if c.streamMetadataCallback.handle(flag, resBody) {
+		return c.receive()
+	}
+
+	if flag&flagTrailer != 0 {
+		return nil, endOfStream(resBody)
+	}
+
+	if err := unmarshalMessage(c.codec, resBody, c.req.out); err != nil {
 		return nil, errors.Wrap(err, "failed to unmarshal response body")
 	}
 
@@ -282,26 +1635,153 @@ func (c *bidiStreamClient) Receive() (*Response, error) {
 }
 
 func (c *bidiStreamClient) Close() error {
+	defer c.cancel()
+	// the stream which is closed before the end is reported as canceled.
+	c.stats.end(context.Canceled)
 	return c.t.Close()
 }
 
 // BidiStreamClient instantiates bidirectional streaming client.
+// The returned BidiStreamClient also implements BidiStream.
 func (c *Client) BidiStreaming(ctx context.Context, req *Request) (BidiStreamClient, error) {
-	t, err := c.stb(c.host, req.endpoint)
+	if err := req.checkKind(true, true); err != nil {
+		return nil, err
+	}
+
+	stats := c.startCall(req.endpoint)
+	ctx, cancel := c.withCallTimeout(ctx)
+	t, err := c.openStream(ctx, req.endpoint)
 	if err != nil {
+		err = contextError(err)
+		stats.end(err)
+		cancel()
 		return nil, err
 	}
 	return &bidiStreamClient{
-		ctx:   ctx,
-		t:     t,
-		req:   req,
-		codec: c.codec,
+		ctx:        ctx,
+		cancel:     cancel,
+		t:          t,
+		req:        req,
+		stats:      stats,
+		codec:      c.codec,
+		compressor: c.compressor,
+
+		compressThreshold: c.compressThreshold,
+
+		sentBytesCallback:      c.sentBytesCallback,
+		streamMetadataCallback: c.streamMetadataCallback,
+		frameHook:              c.frameHook,
 	}, nil
 }
 
+// Invoke sends a request by the API kind which is determined by the method descriptor of req.
+// req must be instantiated by NewMethodRequest.
+//
+// The actual type of the returned value is depends on the API kind:
+//
+//   unary:                   *Response
+//   server streaming:        ServerStreamClient
+//   client streaming:        ClientStreamClient
+//   bidirectional streaming: BidiStreamClient
+//
+// For client streaming APIs, req is not sent. Send it by ClientStreamClient.Send.
+func (c *Client) Invoke(ctx context.Context, req *Request) (interface{}, error) {
+	if req.method == nil {
+		return nil, errors.New("the request has no method descriptor, use NewMethodRequest to instantiate it")
+	}
+
+	switch cs, ss := req.method.GetClientStreaming(), req.method.GetServerStreaming(); {
+	case !cs && !ss:
+		res, err := c.Unary(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	case !cs && ss:
+		return c.ServerStreaming(ctx, req)
+	case cs && !ss:
+		return c.ClientStreaming(ctx)
+	default:
+		return c.BidiStreaming(ctx, req)
+	}
+}
+
+// RoundTrip sends raw request frames to endpoint through the unary transport.
+// Each element of reqFrames must be a frame formed like header (compressed-flag(1) + message-length(4)) + message.
+//
+// RoundTrip returns raw response frames except the trailer, the response headers and the status in the trailer.
+// The returned error reports failures of the transport or the framing, not the status of the API.
+// It is the low-level primitive for advanced users who implement custom API kinds.
+func (c *Client) RoundTrip(ctx context.Context, endpoint string, reqFrames [][]byte) ([][]byte, metadata.MD, *status.Status, error) {
+	ctx, err := c.withRequestMetadata(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	body := bytes.Join(reqFrames, nil)
+	c.sentBytesCallback.call(bytes.NewBuffer(body))
+	c.frameHook.outbound(body)
+
+	t := c.tb(c.host, &Request{endpoint: endpoint}, &c.topts)
+	rawBody, err := t.Send(ctx, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, nil, contextError(errors.Wrap(err, "failed to send the request"))
+	}
+	defer drainBody(rawBody)
+
+	var (
+		r         = bufio.NewReader(rawBody)
+		resFrames [][]byte
+		trailer   metadata.MD
+	)
+	for {
+		flag, content, err := c.frameHook.readFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to read the response body")
+		}
+
+		if flag&flagTrailer != 0 {
+			trailer = parseTrailer(content)
+			break
+		}
+
+		f := make([]byte, headerLen, headerLen+len(content))
+		f[0] = flag
+		binary.BigEndian.PutUint32(f[1:], uint32(len(content)))
+		resFrames = append(resFrames, append(f, content...))
+	}
+
+	return resFrames, t.Header(), status.Convert(statusFromTrailer(trailer, c.strictStatus)), nil
+}
+
 // copied from rpc_util.go#msgHeader
 const headerLen = 5
 
+// maxDrainSize is the max length of the rest of a response body which is read to reuse the connection.
+const maxDrainSize = 64 << 10
+
+// drainBody reads the rest of body up to maxDrainSize so that the connection can be reused, and closes it.
+// A larger body is not worth reading after the result is known, so the connection is closed instead.
+func drainBody(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainSize))
+	body.Close()
+}
+
+// maxTapOverhead is the size allowed for frame headers, metadata frames and the trailer
+// in addition to MaxCallRecvMsgSize when WithResponseTap buffers the response body.
+const maxTapOverhead = 64 << 10
+
+// flags of the frame header.
+// The least significant bit indicates the message is compressed,
+// and the most significant bit indicates the frame is a trailer.
+const (
+	flagCompressed byte = 0x01
+	flagTrailer    byte = 0x80
+)
+
 func header(body []byte) []byte {
 	h := make([]byte, 5)
 	h[0] = byte(0)
@@ -309,41 +1789,379 @@ func header(body []byte) []byte {
 	return h
 }
 
+// bytesCallback receives raw bytes for debugging. nil bytesCallback does nothing.
+type bytesCallback func([]byte)
+
+func (f bytesCallback) call(b *bytes.Buffer) {
+	if f != nil {
+		f(b.Bytes())
+	}
+}
+
+// Direction is the direction of a frame.
+type Direction int
+
+const (
+	// Outbound means the frame is sent to the server.
+	Outbound Direction = iota
+	// Inbound means the frame is received from the server.
+	Inbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Outbound:
+		return "outbound"
+	case Inbound:
+		return "inbound"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// frameHook receives every frame. nil frameHook does nothing.
+type frameHook func(dir Direction, flag byte, body []byte)
+
+// outbound passes each frame in b to the hook.
+func (f frameHook) outbound(b []byte) {
+	if f == nil {
+		return
+	}
+	for len(b) >= headerLen {
+		n := headerLen + int(binary.BigEndian.Uint32(b[1:headerLen]))
+		if n > len(b) {
+			n = len(b)
+		}
+		f(Outbound, b[0], b[headerLen:n])
+		b = b[n:]
+	}
+}
+
+// readFrame reads a frame from r and passes it to the hook.
+func (f frameHook) readFrame(r io.Reader) (byte, []byte, error) {
+	return frameReader{hook: f}.readFrame(r)
+}
+
+// frameReader reads frames with the size limit, and passes them to the hook.
+// Compressed messages are decompressed after being passed to the hook.
+type frameReader struct {
+	hook frameHook
+
+	// limit is the max size of a message. Zero means no limit.
+	limit int
+
+	// compressor decompresses compressed messages. nil means compressed messages are not acceptable.
+	compressor encoding.Compressor
+
+	// onRead is called with the size of each frame on the wire, if not nil.
+	onRead func(n int)
+}
+
+func (fr frameReader) readFrame(r io.Reader) (byte, []byte, error) {
+	flag, body, err := readFrameWithLimit(r, fr.limit)
+	if err != nil {
+		return flag, body, err
+	}
+	if fr.onRead != nil {
+		fr.onRead(headerLen + len(body))
+	}
+	if fr.hook != nil {
+		fr.hook(Inbound, flag, body)
+	}
+
+	if flag&flagTrailer == 0 && flag&flagCompressed != 0 {
+		body, err = decompress(fr.compressor, body, fr.limit)
+		if err != nil {
+			return 0, nil, err
+		}
+		flag &^= flagCompressed
+	}
+	return flag, body, nil
+}
+
+// FrameReader reads raw gRPC Web frames from an underlying reader.
+// It is useful to process responses without decoding messages, e.g. for proxies.
+type FrameReader struct {
+	r io.Reader
+
+	// end is true after the trailer which has grpc-status. Bytes after it are ignored.
+	end bool
+}
+
+// NewFrameReader returns a FrameReader which reads frames from r.
+// Reads from r are buffered, so r must not be read by others after that.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next frame and returns its flag and payload.
+// The most significant bit of the flag indicates the frame is a trailer.
+// Next returns io.EOF if r ends at a frame boundary, and io.ErrUnexpectedEOF if a frame is truncated.
+// The trailer which has grpc-status ends the response, so Next returns io.EOF after it
+// even if r has trailing bytes, e.g. newlines padded by some gateways.
+func (fr *FrameReader) Next() (flag byte, payload []byte, err error) {
+	if fr.end {
+		return 0, nil, io.EOF
+	}
+	flag, payload, err = readFrame(fr.r)
+	if err == nil && flag&flagTrailer != 0 && hasStatus(parseTrailer(payload)) {
+		fr.end = true
+	}
+	return flag, payload, err
+}
+
+// TrailerFrame builds a trailer frame which has the status of code and msg.
+// It is useful to simulate responses in tests, e.g. by returning it from a stub Transport:
+//
+//   grpcweb.TrailerFrame(codes.NotFound, "missing")
+//
+func TrailerFrame(code codes.Code, msg string) []byte {
+	return trailerFrame(statusTrailer(code, msg))
+}
+
 // header (compressed-flag(1) + message-length(4)) + body
-// TODO: compressed message
-func parseRequestBody(codec encoding.Codec, in interface{}) (io.Reader, error) {
+// If comp is not nil, the body is compressed by comp unless it is smaller than threshold.
+func parseRequestBody(codec encoding.Codec, comp encoding.Compressor, threshold int, in interface{}) (*bytes.Buffer, error) {
+	h, body, err := marshalRequest(codec, comp, threshold, in)
+	if err != nil {
+		return nil, err
+	}
+	return framedBuffer(h, body), nil
+}
+
+// framedBuffer copies a frame into a pooled buffer.
+func framedBuffer(header, message []byte) *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.Grow(len(header) + len(message))
+	buf.Write(header)
+	buf.Write(message)
+	return buf
+}
+
+// marshalRequest marshals in, and returns the frame header and the message separately.
+// If comp is not nil, the message is compressed by comp unless it is smaller than threshold.
+func marshalRequest(codec encoding.Codec, comp encoding.Compressor, threshold int, in interface{}) ([]byte, []byte, error) {
 	body, err := codec.Marshal(in)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal the request body")
+		return nil, nil, errors.Wrap(err, "failed to marshal the request body")
+	}
+	if comp != nil && len(body) >= threshold {
+		body, err = compress(comp, body)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to compress the request body by %s", comp.Name())
+		}
+		h := header(body)
+		h[0] = flagCompressed
+		return h, body, nil
+	}
+	return header(body), body, nil
+}
+
+// bufferPool pools buffers of framed request bodies.
+// A buffer may be released by releaseBuffer only after nobody refers to it.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledBufferSize is the max capacity of buffers returned to the pool,
+// so that a large message doesn't pin a large buffer.
+const maxPooledBufferSize = 1 << 20
+
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// pooledBody shares a pooled buffer between readers, and releases it after all references are dropped.
+// net/http closes request bodies after writing them, which may be after the response arrives.
+type pooledBody struct {
+	m    sync.Mutex
+	refs int
+	buf  *bytes.Buffer
+
+	// header and message are the frame of a large message, which is not copied into buf. (buf is nil)
+	header, message []byte
+}
+
+// newPooledBody returns a pooledBody which has a reference held by the caller.
+func newPooledBody(buf *bytes.Buffer) *pooledBody {
+	return &pooledBody{refs: 1, buf: buf}
+}
+
+// newFrameBody returns a pooledBody which reads header and message in order without copying them.
+func newFrameBody(header, message []byte) *pooledBody {
+	return &pooledBody{refs: 1, header: header, message: message}
+}
+
+// reader returns a new reader of the buffer. The reader drops its reference on Close.
+func (b *pooledBody) reader() *requestBody {
+	b.m.Lock()
+	b.refs++
+	b.m.Unlock()
+	if b.buf == nil {
+		return &requestBody{
+			Reader: io.MultiReader(bytes.NewReader(b.header), bytes.NewReader(b.message)),
+			size:   len(b.header) + len(b.message),
+			body:   b,
+		}
+	}
+	return &requestBody{Reader: bytes.NewReader(b.buf.Bytes()), size: b.buf.Len(), body: b}
+}
+
+func (b *pooledBody) unref() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.refs--
+	if b.refs == 0 && b.buf != nil {
+		releaseBuffer(b.buf)
+	}
+}
+
+// requestBody is a request body backed by a pooledBody.
+type requestBody struct {
+	io.Reader
+	size int
+
+	once sync.Once
+	body *pooledBody
+}
+
+func (b *requestBody) Close() error {
+	b.once.Do(b.body.unref)
+	return nil
+}
+
+// responseCompressor returns the compressor which decompresses response messages, by grpc-encoding header in md.
+// If the header is absent, fallback is returned.
+func responseCompressor(md metadata.MD, fallback encoding.Compressor) (encoding.Compressor, error) {
+	v := md.Get("grpc-encoding")
+	if len(v) == 0 || v[0] == "" {
+		return fallback, nil
+	}
+	if v[0] == "identity" {
+		return nil, nil
+	}
+	if fallback != nil && fallback.Name() == v[0] {
+		return fallback, nil
+	}
+	comp := encoding.GetCompressor(v[0])
+	if comp == nil {
+		return nil, status.Errorf(codes.Internal, "no compressor is registered for grpc-encoding %q", v[0])
+	}
+	return comp, nil
+}
+
+func compress(comp encoding.Compressor, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := comp.Compress(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses b by comp.
+// It returns ResourceExhausted error if the decompressed message is larger than limit. Zero limit means no limit.
+func decompress(comp encoding.Compressor, b []byte, limit int) ([]byte, error) {
+	if comp == nil {
+		return nil, status.Error(codes.Internal, "received a compressed message, but no compressor is configured")
+	}
+	r, err := comp.Decompress(bytes.NewReader(b))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to decompress the message by %s: %s", comp.Name(), err)
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to decompress the message by %s: %s", comp.Name(), err)
+	}
+	if limit > 0 && len(out) > limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "received message after decompression larger than max (%d vs. %d)", len(out), limit)
+	}
+	return out, nil
+}
+
+// unmarshalMessage unmarshals a message frame into out.
+// A zero-length frame is a valid empty message (e.g. google.protobuf.Empty),
+// so out is reset instead of being passed to codecs which can't decode empty input.
+func unmarshalMessage(codec encoding.Codec, b []byte, out interface{}) error {
+	if len(b) == 0 {
+		if m, ok := out.(proto.Message); ok {
+			m.Reset()
+			return nil
+		}
 	}
-	buf := bytes.NewBuffer(make([]byte, 0, headerLen+len(body)))
-	buf.Write(header(body))
-	buf.Write(body)
-	return buf, nil
+	return codec.Unmarshal(b, out)
 }
 
 // copied from rpc_util#parser.recvMsg
 // TODO: compressed message
 func parseResponseBody(resBody io.Reader) ([]byte, error) {
+	_, content, err := readFrame(resBody)
+	return content, err
+}
+
+// bufferedBody buffers a response body, so that reading a stream of small frames doesn't issue
+// two reads (the header and the content) of the underlying connection per frame.
+type bufferedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+func newBufferedBody(rc io.ReadCloser) io.ReadCloser {
+	return &bufferedBody{Reader: bufio.NewReader(rc), Closer: rc}
+}
+
+// readFrame reads a frame from resBody and returns its flag and content.
+// It returns io.EOF only if resBody ends at a frame boundary.
+func readFrame(resBody io.Reader) (byte, []byte, error) {
+	return readFrameWithLimit(resBody, 0)
+}
+
+// readFrameWithLimit is same as readFrame, but returns ResourceExhausted error
+// if the content is larger than limit before reading it. Zero limit means no limit.
+func readFrameWithLimit(resBody io.Reader, limit int) (byte, []byte, error) {
 	var h [5]byte
-	if _, err := resBody.Read(h[:]); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(resBody, h[:]); err != nil {
+		return 0, nil, err
 	}
 
 	length := binary.BigEndian.Uint32(h[1:])
 	if length == 0 {
-		return nil, nil
+		return h[0], nil, nil
 	}
 
-	// TODO: check message size
+	if limit > 0 && int64(length) > int64(limit) {
+		return 0, nil, status.Errorf(codes.ResourceExhausted, "received message larger than max (%d vs. %d)", length, limit)
+	}
 
-	content := make([]byte, int(length))
-	if n, err := resBody.Read(content); err != nil {
-		if err == io.EOF && int(n) != int(length) {
+	// the buffer grows as the content arrives, so the declared length
+	// doesn't allocate the whole content before it is actually received.
+	var buf bytes.Buffer
+	if length <= maxPrealloc {
+		buf.Grow(int(length))
+	}
+	if _, err := io.CopyN(&buf, resBody, int64(length)); err != nil {
+		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
-		return nil, err
+		return 0, nil, err
 	}
 
-	return content, nil
+	return h[0], buf.Bytes(), nil
 }
+
+// maxPrealloc is the max content length which readFrameWithLimit allocates before reading.
+const maxPrealloc = 64 << 10
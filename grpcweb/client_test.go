@@ -1,22 +1,49 @@
 package grpcweb
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/gorilla/websocket"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/ktr0731/grpc-test/server"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 var defaultAddr = "localhost:50051"
@@ -32,7 +59,7 @@ type protoHelper struct {
 	m map[string]*dynamic.Message
 }
 
-func (h *protoHelper) getServiceByName(t *testing.T, n string) *descriptor.ServiceDescriptorProto {
+func (h *protoHelper) getServiceByName(t testing.TB, n string) *descriptor.ServiceDescriptorProto {
 	if h.s == nil {
 		h.s = map[string]*descriptor.ServiceDescriptorProto{}
 		for _, svc := range h.GetServices() {
@@ -46,7 +73,7 @@ func (h *protoHelper) getServiceByName(t *testing.T, n string) *descriptor.Servi
 	return svc
 }
 
-func (h *protoHelper) getMessageTypeByName(t *testing.T, n string) *dynamic.Message {
+func (h *protoHelper) getMessageTypeByName(t testing.TB, n string) *dynamic.Message {
 	if h.m == nil {
 		h.m = map[string]*dynamic.Message{}
 		for _, msg := range h.GetMessageTypes() {
@@ -60,7 +87,7 @@ func (h *protoHelper) getMessageTypeByName(t *testing.T, n string) *dynamic.Mess
 	return msg
 }
 
-func getAPIProto(t *testing.T) *protoHelper {
+func getAPIProto(t testing.TB) *protoHelper {
 	t.Helper()
 
 	pkgs := parseProto(t, "api.proto")
@@ -69,7 +96,7 @@ func getAPIProto(t *testing.T) *protoHelper {
 	return &protoHelper{FileDescriptor: pkgs[0]}
 }
 
-func readFile(t *testing.T, fname string) []byte {
+func readFile(t testing.TB, fname string) []byte {
 	b, err := ioutil.ReadFile(filepath.Join("testdata", fname))
 	require.NoError(t, err)
 	return b
@@ -79,96 +106,2197 @@ type stubTransport struct {
 	host string
 	req  *Request
 
+	// sent is the request body passed to Send.
+	sent []byte
+
+	header metadata.MD
+	res    []byte
+}
+
+func (t *stubTransport) Send(_ context.Context, body io.Reader) (io.ReadCloser, error) {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	t.sent = b
+	return ioutil.NopCloser(bytes.NewReader(t.res)), nil
+}
+
+func (t *stubTransport) Header() metadata.MD {
+	return t.header
+}
+
+// bodyTransport returns body as the response body.
+type bodyTransport struct {
+	body io.ReadCloser
+}
+
+func (t bodyTransport) Send(context.Context, io.Reader) (io.ReadCloser, error) {
+	return t.body, nil
+}
+
+func (bodyTransport) Header() metadata.MD {
+	return nil
+}
+
+// closeRecorder records whether it is closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+// countReader is an endless reader which counts the read bytes.
+type countReader struct {
+	n int
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	r.n += len(p)
+	return len(p), nil
+}
+
+// logger records logs.
+type logger struct {
+	debug, error []string
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.error = append(l.error, fmt.Sprintf(format, args...))
+}
+
+// frame builds a grpc-web frame from flag and body.
+func frame(flag byte, body []byte) []byte {
+	b := make([]byte, headerLen, headerLen+len(body))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:], uint32(len(body)))
+	return append(b, body...)
+}
+
+type stubStreamTransport struct {
 	res []byte
 }
 
-func (t *stubTransport) Send(_ context.Context, body io.Reader) (io.ReadCloser, error) {
-	return ioutil.NopCloser(bytes.NewReader(t.res)), nil
-}
+func (b *stubStreamTransport) Send(body io.Reader) error {
+	return nil
+}
+
+func (b *stubStreamTransport) Receive() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(b.res)), nil
+}
+
+func (b *stubStreamTransport) Ping(context.Context) error {
+	return nil
+}
+
+func (b *stubStreamTransport) CloseSend() error {
+	return nil
+}
+
+func (b *stubStreamTransport) Finish() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(b.res)), nil
+}
+
+func (b *stubStreamTransport) Close() error {
+	return nil
+}
+
+// blockingStreamTransport blocks Receive until it is closed.
+type blockingStreamTransport struct {
+	stubStreamTransport
+
+	closed chan struct{}
+	n      int
+}
+
+func (b *blockingStreamTransport) Receive() (io.ReadCloser, error) {
+	<-b.closed
+	return nil, io.ErrClosedPipe
+}
+
+func (b *blockingStreamTransport) Close() error {
+	b.n++
+	close(b.closed)
+	return nil
+}
+
+// stubCredentials issues a new token for each request.
+type stubCredentials struct {
+	n   int
+	err error
+}
+
+func (c *stubCredentials) GetRequestMetadata(context.Context) (map[string]string, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.n++
+	return map[string]string{"authorization": fmt.Sprintf("Bearer token%d", c.n)}, nil
+}
+
+// stubCodec is a codec which doesn't marshal anything.
+type stubCodec struct {
+	name string
+}
+
+func (c *stubCodec) Marshal(v interface{}) ([]byte, error)      { return nil, nil }
+func (c *stubCodec) Unmarshal(data []byte, v interface{}) error { return nil }
+func (c *stubCodec) Name() string                               { return c.name }
+
+// for testing
+func withStubTransport(t *stubTransport, st *stubStreamTransport) ClientOption {
+	stubBuilder := func(host string, req *Request, _ *TransportOptions) Transport {
+		t.host = host
+		t.req = req
+		return t
+	}
+	stubStreamBuilder := func(_ context.Context, host string, endpoint string, _ *TransportOptions) (StreamTransport, error) {
+		return st, nil
+	}
+	return func(c *Client) {
+		c.tb = stubBuilder
+		c.stb = stubStreamBuilder
+	}
+}
+
+func TestClient(t *testing.T) {
+	pkg := getAPIProto(t)
+	service := pkg.getServiceByName(t, "Example")
+	endpoint := ToEndpoint("api", service, service.GetMethod()[0])
+
+	t.Run("ToEndpoint", func(t *testing.T) {
+		expected := fmt.Sprintf("/api.%s/%s", service.GetName(), service.GetMethod()[0].GetName())
+		assert.Equal(t, expected, endpoint)
+	})
+
+	t.Run("NewClient returns new API client", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{}, nil))
+		assert.NotNil(t, client)
+	})
+
+	t.Run("Send an unary API", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: readFile(t, "unary_ktr.out"),
+		}, nil))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		req := NewRequest(endpoint, in, out)
+		res, err := client.Unary(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+	})
+
+	t.Run("Unary round-trips dynamic messages", func(t *testing.T) {
+		// the server echoes the request message. SimpleRequest and SimpleResponse have the same wire format.
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, b, err := readFrame(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write(append(frame(0x00, b), frame(0x80, []byte("grpc-status: 0\r\n"))...))
+		}))
+		defer srv.Close()
+
+		in := dynamic.NewMessage(pkg.FindMessage("api.SimpleRequest"))
+		in.SetFieldByName("name", "ktr")
+		out := dynamic.NewMessage(pkg.FindMessage("api.SimpleResponse"))
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.True(t, res.Content == out, "the response must be unmarshaled into out")
+		assert.Equal(t, "ktr", out.GetFieldByName("message"))
+	})
+
+	t.Run("Unary accepts an empty response message", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: append(frame(0x00, nil), frame(0x80, []byte("grpc-status: 0\r\n"))...),
+		}, nil))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), &empty.Empty{}
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.Equal(t, out, res.Content)
+
+		t.Run("the previous content is reset", func(t *testing.T) {
+			out := pkg.getMessageTypeByName(t, "SimpleResponse")
+			out.SetFieldByName("message", "stale")
+			res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			require.NoError(t, err)
+			assert.Equal(t, "", extractMessage(t, res))
+		})
+	})
+
+	t.Run("Unary handles grpc-status in the trailer", func(t *testing.T) {
+		message := readFile(t, "unary_ktr.out")[: headerLen+12 : headerLen+12]
+
+		cases := map[string]struct {
+			res    []byte
+			strict bool
+			code   codes.Code
+		}{
+			"status 0":                     {res: readFile(t, "unary_ktr.out"), code: codes.OK},
+			"absent status":                {res: append(message, frame(0x80, []byte("foo: bar\r\n"))...), code: codes.OK},
+			"empty status":                 {res: append(message, frame(0x80, []byte("grpc-status: \r\n"))...), code: codes.OK},
+			"no trailer":                   {res: message, code: codes.OK},
+			"trailers-only":                {res: frame(0x80, []byte("grpc-status: 5\r\ngrpc-message: not%20found\r\n")), code: codes.NotFound},
+			"absent status in strict mode": {res: message, strict: true, code: codes.Internal},
+			"empty status in strict mode":  {res: append(message, frame(0x80, []byte("grpc-status: \r\n"))...), strict: true, code: codes.OK},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				opts := []ClientOption{withStubTransport(&stubTransport{res: c.res}, nil)}
+				if c.strict {
+					opts = append(opts, WithStrictStatus())
+				}
+				client := NewClient(defaultAddr, opts...)
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+				assert.Equal(t, c.code, status.Code(err), "%v", err)
+			})
+		}
+
+		t.Run("grpc-message is decoded", func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+				res: frame(0x80, []byte("grpc-status: 5\r\ngrpc-message: not%20found\r\n")),
+			}, nil))
+
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			assert.Equal(t, "not found", status.Convert(err).Message())
+		})
+	})
+
+	t.Run("WithTrailerValidator rejects responses by the trailer", func(t *testing.T) {
+		message := readFile(t, "unary_ktr.out")[: headerLen+12 : headerLen+12]
+		validator := func(md metadata.MD) error {
+			if len(md.Get("x-signature")) == 0 {
+				return status.Error(codes.DataLoss, "x-signature is missing")
+			}
+			return nil
+		}
+
+		cases := map[string]struct {
+			res  []byte
+			code codes.Code
+		}{
+			"signed":           {res: append(message, frame(0x80, []byte("grpc-status: 0\r\nx-signature: foo\r\n"))...), code: codes.OK},
+			"missing":          {res: append(message, frame(0x80, []byte("grpc-status: 0\r\n"))...), code: codes.DataLoss},
+			"non-OK is kept":   {res: frame(0x80, []byte("grpc-status: 5\r\n")), code: codes.NotFound},
+			"missing trailers": {res: message, code: codes.DataLoss},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: c.res}, nil), WithTrailerValidator(validator))
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+				assert.Equal(t, c.code, status.Code(err), "%v", err)
+			})
+		}
+	})
+
+	t.Run("WithSentBytesCallback receives the framed request body", func(t *testing.T) {
+		var sent []byte
+		st := &stubTransport{
+			res: readFile(t, "unary_ktr.out"),
+		}
+		client := NewClient(defaultAddr, withStubTransport(st, nil), WithSentBytesCallback(func(b []byte) {
+			sent = append([]byte(nil), b...)
+		}))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		in.SetFieldByName("name", "ktr")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		require.True(t, len(sent) > headerLen)
+		assert.Equal(t, byte(0), sent[0])
+		assert.Equal(t, uint32(len(sent)-headerLen), binary.BigEndian.Uint32(sent[1:headerLen]))
+		assert.Equal(t, st.sent, sent)
+	})
+
+	t.Run("WithFrameHook receives every frame", func(t *testing.T) {
+		type record struct {
+			dir  Direction
+			flag byte
+			body string
+		}
+		var records []record
+		hook := func(dir Direction, flag byte, body []byte) {
+			records = append(records, record{dir, flag, string(body)})
+		}
+		message := readFile(t, "unary_ktr.out")[:headerLen+12]
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: append(append([]byte(nil), message...), frame(0x80, []byte("grpc-status: 0\r\n"))...),
+		}, nil), WithFrameHook(hook))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		in.SetFieldByName("name", "ktr")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		reqBody, err := in.Marshal()
+		require.NoError(t, err)
+		assert.Equal(t, []record{
+			{Outbound, 0x00, string(reqBody)},
+			{Inbound, 0x00, string(message[headerLen:])},
+			{Inbound, 0x80, "grpc-status: 0\r\n"},
+		}, records)
+	})
+
+	t.Run("WithResponseTap receives the raw response body", func(t *testing.T) {
+		cases := map[string]struct {
+			status      int
+			contentType string
+			body        []byte
+		}{
+			"OK":      {status: http.StatusOK, contentType: "application/grpc-web+proto", body: readFile(t, "unary_ktr.out")},
+			"HTML":    {status: http.StatusOK, contentType: "text/html", body: []byte("<html>proxy error</html>")},
+			"non-200": {status: http.StatusBadGateway, contentType: "text/html", body: []byte("<html>bad gateway</html>")},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("content-type", c.contentType)
+					w.WriteHeader(c.status)
+					w.Write(c.body)
+				}))
+				defer srv.Close()
+
+				var tapped []byte
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithResponseTap(func(b []byte) {
+					tapped = append([]byte(nil), b...)
+				}))
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				client.Unary(context.Background(), NewRequest(endpoint, in, out))
+
+				assert.Equal(t, c.body, tapped)
+			})
+		}
+	})
+
+	t.Run("WithResponseTap is bounded by MaxCallRecvMsgSize", func(t *testing.T) {
+		var tapped bool
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: append(frame(0x00, make([]byte, maxTapOverhead+1024)), TrailerFrame(codes.OK, "")...),
+		}, nil), WithResponseTap(func([]byte) { tapped = true }))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out), MaxCallRecvMsgSize(1024))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.False(t, tapped)
+	})
+
+	t.Run("the rest of the response body is drained up to a limit", func(t *testing.T) {
+		rest := &countReader{}
+		body := &closeRecorder{Reader: io.MultiReader(bytes.NewReader(TrailerFrame(codes.OK, "")), rest)}
+		client := NewClient(defaultAddr, WithTransportBuilder(func(string, *Request) Transport {
+			return bodyTransport{body: body}
+		}))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.True(t, rest.n <= maxDrainSize)
+		assert.True(t, body.closed)
+	})
+
+	t.Run("WithLogger receives logs of the call", func(t *testing.T) {
+		var (
+			logger logger
+			frames int
+		)
+		message := readFile(t, "unary_ktr.out")[:headerLen+12]
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: append(append([]byte(nil), message...), frame(0x80, []byte("grpc-status: 0\r\n"))...),
+		}, nil), WithLogger(&logger), WithFrameHook(func(Direction, byte, []byte) { frames++ }))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		in.SetFieldByName("name", "ktr")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"grpcweb: outbound message frame (flag: 0x00, length: 5)",
+			"grpcweb: sending a request to " + endpoint,
+			"grpcweb: inbound message frame (flag: 0x00, length: 12)",
+			"grpcweb: inbound trailer frame: map[grpc-status:[0]]",
+		}, logger.debug)
+		assert.Empty(t, logger.error)
+		assert.Equal(t, 3, frames, "the frame hook must be called with the logger")
+	})
+
+	t.Run("WithPerRPCCredentials attaches request metadata for each request", func(t *testing.T) {
+		var tokens []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokens = append(tokens, r.Header.Get("authorization"))
+			w.Write(readFile(t, "unary_ktr.out"))
+		}))
+		defer srv.Close()
+
+		creds := &stubCredentials{}
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithPerRPCCredentials(creds))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		for i := 0; i < 2; i++ {
+			_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []string{"Bearer token1", "Bearer token2"}, tokens)
+
+		creds.err = errors.New("expired")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		assert.Error(t, err)
+	})
+
+	t.Run("WithPerRPCCredentials attaches request metadata to streams", func(t *testing.T) {
+		type headers struct{ handshake, stream string }
+		recv := make(chan headers, 1)
+		upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			h, err := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("\r\n")))).ReadMIMEHeader()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			recv <- headers{handshake: r.Header.Get("authorization"), stream: h.Get("authorization")}
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithPerRPCCredentials(&stubCredentials{}))
+		s, err := client.BidiStreaming(context.Background(), NewRequest("/api.Example/BidiStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}))
+		require.NoError(t, err)
+		defer s.Close()
+		require.NoError(t, s.Send(NewRequest("/api.Example/BidiStreaming", &wrappers.StringValue{}, &wrappers.StringValue{})))
+
+		assert.Equal(t, headers{handshake: "Bearer token1", stream: "Bearer token1"}, <-recv)
+	})
+
+	t.Run("WithContextMetadata", func(t *testing.T) {
+		cases := map[string]struct {
+			opts     []ClientOption
+			expected []string
+		}{
+			"enabled":  {opts: []ClientOption{WithContextMetadata()}, expected: []string{"a", "b"}},
+			"disabled": {},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var header http.Header
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					header = r.Header
+					w.Write(readFile(t, "unary_ktr.out"))
+				}))
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.opts, WithInsecure())...)
+				ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-foo", "a", "x-foo", "b"))
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(ctx, NewRequest(endpoint, in, out))
+				require.NoError(t, err)
+
+				assert.Equal(t, c.expected, header[http.CanonicalHeaderKey("x-foo")])
+			})
+		}
+	})
+
+	t.Run("WithUserAgent", func(t *testing.T) {
+		cases := map[string]struct {
+			opts      []ClientOption
+			userAgent string
+		}{
+			"default":    {userAgent: "grpc-web-go-client/" + Version},
+			"overridden": {opts: []ClientOption{WithUserAgent("foo/1.0")}, userAgent: "foo/1.0"},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var header http.Header
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					header = r.Header
+				}))
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.opts, WithInsecure())...)
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				client.Unary(context.Background(), NewRequest(endpoint, in, out))
+
+				assert.Equal(t, c.userAgent, header.Get("user-agent"))
+			})
+		}
+	})
+
+	t.Run("Header and Trailer are populated after the call", func(t *testing.T) {
+		b, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+		require.NoError(t, err)
+		cases := map[string]struct {
+			res  []byte
+			code codes.Code
+		}{
+			"OK":            {res: append(frame(0x00, b), frame(flagTrailer, []byte("grpc-status: 0\r\nx-trailer: bar\r\n"))...), code: codes.OK},
+			"trailers-only": {res: frame(flagTrailer, []byte("grpc-status: 5\r\nx-trailer: bar\r\n")), code: codes.NotFound},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+					header: metadata.Pairs("content-encoding", "identity"),
+					res:    c.res,
+				}, nil))
+
+				var header, trailer metadata.MD
+				_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}), Header(&header), Trailer(&trailer))
+				assert.Equal(t, c.code, status.Code(err))
+				assert.Equal(t, []string{"identity"}, header.Get("content-encoding"))
+				assert.Equal(t, []string{"bar"}, trailer.Get("x-trailer"))
+				assert.Empty(t, header.Get("x-trailer"), "the trailer must not be mixed into the header")
+			})
+		}
+
+		t.Run("server streaming", func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+				header: metadata.Pairs("x-header", "foo"),
+				res:    append(frame(0x00, b), frame(flagTrailer, []byte("grpc-status: 0\r\nx-trailer: bar\r\n"))...),
+			}, nil))
+
+			var header, trailer metadata.MD
+			s, err := client.ServerStreaming(context.Background(), NewRequest("/api.Example/ServerStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}), Header(&header), Trailer(&trailer))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"foo"}, header.Get("x-header"))
+
+			_, err = s.Receive()
+			require.NoError(t, err)
+			assert.Nil(t, trailer)
+			_, err = s.Receive()
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, []string{"bar"}, trailer.Get("x-trailer"))
+		})
+	})
+
+	t.Run("Progress reports received bytes", func(t *testing.T) {
+		b, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+		require.NoError(t, err)
+		message, trailer := frame(0x00, b), TrailerFrame(codes.OK, "")
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: append(append(append([]byte(nil), message...), message...), trailer...),
+		}, nil))
+
+		var progress []int64
+		s, err := client.ServerStreaming(context.Background(), NewRequest("/api.Example/ServerStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}), Progress(func(n int64) {
+			progress = append(progress, n)
+		}))
+		require.NoError(t, err)
+		for err == nil {
+			_, err = s.Receive()
+		}
+		assert.Equal(t, io.EOF, err)
+
+		m, tr := int64(len(message)), int64(len(trailer))
+		assert.Equal(t, []int64{m, 2 * m, 2*m + tr}, progress)
+	})
+
+	t.Run("ServerStream has the shape of grpc.ClientStream", func(t *testing.T) {
+		b, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+		require.NoError(t, err)
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			header: metadata.Pairs("x-header", "foo"),
+			res:    append(frame(0x00, b), frame(flagTrailer, []byte("grpc-status: 0\r\nx-trailer: bar\r\n"))...),
+		}, nil))
+
+		ctx := context.WithValue(context.Background(), struct{}{}, "value")
+		stream, err := client.ServerStreaming(ctx, NewRequest("/api.Example/ServerStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}))
+		require.NoError(t, err)
+		require.Implements(t, (*ServerStream)(nil), stream)
+		s := stream.(ServerStream)
+		assert.Equal(t, "value", s.Context().Value(struct{}{}))
+
+		header, err := s.Header()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo"}, header.Get("x-header"))
+
+		m, err := s.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, "foo", m.(*wrappers.StringValue).Value)
+		assert.Nil(t, s.Trailer())
+
+		_, err = s.Recv()
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, []string{"bar"}, s.Trailer().Get("x-trailer"))
+	})
+
+	t.Run("the end of a server stream releases the response body and the context", func(t *testing.T) {
+		cases := map[string]struct {
+			res  []byte
+			code codes.Code
+		}{
+			"OK":           {res: frame(flagTrailer, []byte("grpc-status: 0\r\n")), code: codes.OK},
+			"error status": {res: frame(flagTrailer, []byte("grpc-status: 5\r\n")), code: codes.NotFound},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				body := &closeRecorder{Reader: bytes.NewReader(c.res)}
+				client := NewClient(defaultAddr, WithCallTimeout(time.Minute), WithTransportBuilder(func(string, *Request) Transport {
+					return bodyTransport{body: body}
+				}))
+				stream, err := client.ServerStreaming(context.Background(), NewRequest("/api.Example/ServerStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}))
+				require.NoError(t, err)
+
+				_, err = stream.Receive()
+				if c.code == codes.OK {
+					assert.Equal(t, io.EOF, err)
+				} else {
+					assert.Equal(t, c.code, status.Code(err), "%v", err)
+				}
+				assert.True(t, body.closed, "the response body must be closed")
+				assert.Equal(t, context.Canceled, stream.(ServerStream).Context().Err())
+			})
+		}
+	})
+
+	t.Run("BidiStream requires the optional interfaces of the stream transport", func(t *testing.T) {
+		req := NewRequest("/api.Example/BidiStreaming", &wrappers.StringValue{}, &wrappers.StringValue{})
+
+		client := NewClient(defaultAddr, withStubTransport(nil, &stubStreamTransport{}))
+		s, err := client.BidiStreaming(context.Background(), req)
+		require.NoError(t, err)
+		require.Implements(t, (*BidiStream)(nil), s)
+		assert.NoError(t, s.(BidiStream).CloseSend())
+		assert.NoError(t, s.(BidiStream).Ping(context.Background()))
+
+		// the embedded interface hides CloseSend and Ping of stubStreamTransport.
+		client = NewClient(defaultAddr, WithStreamTransportBuilder(func(string, string) (StreamTransport, error) {
+			return struct{ StreamTransport }{&stubStreamTransport{}}, nil
+		}))
+		s, err = client.BidiStreaming(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, codes.Unimplemented, status.Code(s.(BidiStream).CloseSend()))
+		assert.Equal(t, codes.Unimplemented, status.Code(s.(BidiStream).Ping(context.Background())))
+	})
+
+	t.Run("Peer is populated after the call", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(readFile(t, "unary_ktr.out"))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+
+		var p peer.Peer
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out), Peer(&p))
+		require.NoError(t, err)
+		require.NotNil(t, p.Addr)
+		assert.Equal(t, srv.Listener.Addr().String(), p.Addr.String())
+		assert.Nil(t, p.AuthInfo)
+	})
+
+	t.Run("CallPath overrides the endpoint", func(t *testing.T) {
+		var paths []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			w.Write(readFile(t, "unary_ktr.out"))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithBasePath("/gw"))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		req := NewRequest(endpoint, in, out)
+
+		_, err := client.Unary(context.Background(), req, CallPath("v2.Example/Unary"))
+		require.NoError(t, err)
+		s, err := client.ServerStreaming(context.Background(), req, CallPath("/v2.Example/Stream"))
+		require.NoError(t, err)
+		s.(ServerStream).Cancel()
+		_, err = client.Unary(context.Background(), req)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"/gw/v2.Example/Unary", "/gw/v2.Example/Stream", "/gw" + endpoint}, paths)
+		assert.Equal(t, endpoint, req.Endpoint(), "the request must not be modified")
+	})
+
+	t.Run("WithDefaultCallOptions applies CallOptions to every call", func(t *testing.T) {
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+
+		cases := map[string]struct {
+			opts []CallOption
+			code codes.Code
+		}{
+			"default":    {code: codes.ResourceExhausted},
+			"overridden": {opts: []CallOption{MaxCallRecvMsgSize(1024)}, code: codes.OK},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+					res: readFile(t, "unary_ktr.out"),
+				}, nil), WithDefaultCallOptions(MaxCallRecvMsgSize(4)))
+
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out), c.opts...)
+				assert.Equal(t, c.code, status.Code(err), "%v", err)
+			})
+		}
+
+		t.Run("server streaming", func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+				res: readFile(t, "server_ktr.out"),
+			}, nil), WithDefaultCallOptions(MaxCallRecvMsgSize(4)))
+
+			s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+			require.NoError(t, err)
+			_, err = s.Receive()
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err), "%v", err)
+		})
+	})
+
+	t.Run("WithCompressionThreshold skips compressing small messages", func(t *testing.T) {
+		cases := map[string]struct {
+			value string
+			flag  byte
+		}{
+			"small": {value: "foo", flag: 0x00},
+			"large": {value: strings.Repeat("foo", 100), flag: flagCompressed},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				tr := &stubTransport{res: TrailerFrame(codes.OK, "")}
+				client := NewClient(defaultAddr, withStubTransport(tr, nil), WithCompressor(encoding.GetCompressor("gzip")), WithCompressionThreshold(100))
+				_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{Value: c.value}, &wrappers.StringValue{}))
+				require.NoError(t, err)
+				require.NotEmpty(t, tr.sent)
+				assert.Equal(t, c.flag, tr.sent[0])
+			})
+		}
+	})
+
+	t.Run("WithCompressor compresses messages", func(t *testing.T) {
+		gz := encoding.GetCompressor("gzip")
+		var (
+			header  http.Header
+			reqBody []byte
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			flag, b, err := readFrame(r.Body)
+			if err != nil || flag != 0x01 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reqBody, err = decompress(gz, b, 0)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			res := readFile(t, "unary_ktr.out")
+			message, err := compress(gz, res[headerLen:headerLen+12])
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(append(frame(0x01, message), res[headerLen+12:]...))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithCompressor(gz))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		in.SetFieldByName("name", "ktr")
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+
+		assert.Equal(t, "gzip", header.Get("grpc-encoding"))
+		assert.Equal(t, "gzip", header.Get("grpc-accept-encoding"))
+		expected, err := in.Marshal()
+		require.NoError(t, err)
+		assert.Equal(t, expected, reqBody)
+
+		t.Run("grpc-encoding response header selects the compressor", func(t *testing.T) {
+			message, err := compress(gz, readFile(t, "unary_ktr.out")[headerLen:headerLen+12])
+			require.NoError(t, err)
+
+			cases := map[string]struct {
+				encoding string
+				code     codes.Code
+			}{
+				"registered":   {encoding: "gzip", code: codes.OK},
+				"unregistered": {encoding: "foo", code: codes.Internal},
+			}
+			for name, c := range cases {
+				c := c
+				t.Run(name, func(t *testing.T) {
+					client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+						res:    frame(0x01, message),
+						header: metadata.Pairs("grpc-encoding", c.encoding),
+					}, nil))
+
+					_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+					assert.Equal(t, c.code, status.Code(err), "%v", err)
+				})
+			}
+		})
+
+		t.Run("compressed message without compressor", func(t *testing.T) {
+			message, err := compress(gz, readFile(t, "unary_ktr.out")[headerLen:headerLen+12])
+			require.NoError(t, err)
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: frame(0x01, message)}, nil))
+
+			_, err = client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			assert.Equal(t, codes.Internal, status.Code(err), "%v", err)
+		})
+	})
+
+	t.Run("WithAcceptCompressors negotiates the compression of responses", func(t *testing.T) {
+		gz := encoding.GetCompressor("gzip")
+		var header http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			flag, _, err := readFrame(r.Body)
+			if err != nil || flag != 0x00 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			// the server compresses the response by the compressor which the client accepts.
+			res := readFile(t, "unary_ktr.out")
+			message, err := compress(gz, res[headerLen:headerLen+12])
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			w.Header().Set("grpc-encoding", "gzip")
+			w.Write(append(frame(0x01, message), res[headerLen+12:]...))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithAcceptCompressors("gzip", "unregistered"))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		in.SetFieldByName("name", "ktr")
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+
+		assert.Empty(t, header.Get("grpc-encoding"), "requests must not be compressed")
+		assert.Equal(t, "gzip", header.Get("grpc-accept-encoding"), "unregistered compressors must not be accepted")
+	})
+
+	t.Run("WithBasePath", func(t *testing.T) {
+		cases := map[string]string{
+			"":       endpoint,
+			"/grpc":  "/grpc" + endpoint,
+			"grpc/":  "/grpc" + endpoint,
+			"/a/b/c": "/a/b/c" + endpoint,
+		}
+		for prefix, expected := range cases {
+			prefix, expected := prefix, expected
+			t.Run(prefix, func(t *testing.T) {
+				var path string
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					path = r.URL.Path
+					w.Write(readFile(t, "unary_ktr.out"))
+				}))
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithBasePath(prefix))
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+				require.NoError(t, err)
+				assert.Equal(t, expected, path)
+			})
+		}
+	})
+
+	t.Run("concurrent calls don't share request bodies", func(t *testing.T) {
+		// the server echoes the request message.
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, b, err := readFrame(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write(append(frame(0x00, b), frame(0x80, []byte("grpc-status: 0\r\n"))...))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		defer client.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := strings.Repeat(fmt.Sprint(i), i+1)
+				for j := 0; j < 10; j++ {
+					out := &wrappers.StringValue{}
+					_, err := client.Unary(context.Background(), NewRequest(endpoint, &wrappers.StringValue{Value: name}, out))
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, name, out.Value)
+
+					// server streams also return the request body to the pool at the end.
+					out = &wrappers.StringValue{}
+					s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, &wrappers.StringValue{Value: name}, out))
+					if !assert.NoError(t, err) {
+						return
+					}
+					_, err = s.Receive()
+					assert.NoError(t, err)
+					assert.Equal(t, name, out.Value)
+					_, err = s.Receive()
+					assert.Equal(t, io.EOF, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("WithCallTimeout", func(t *testing.T) {
+		units := map[byte]time.Duration{'n': time.Nanosecond, 'u': time.Microsecond, 'm': time.Millisecond, 'S': time.Second, 'M': time.Minute, 'H': time.Hour}
+		cases := map[string]struct {
+			callTimeout, ctxTimeout time.Duration
+			min, max                time.Duration
+		}{
+			"call timeout":            {callTimeout: time.Hour, min: 59 * time.Minute, max: time.Hour},
+			"shorter context timeout": {callTimeout: time.Hour, ctxTimeout: time.Second, min: 0, max: time.Second},
+			"shorter call timeout":    {callTimeout: time.Second, ctxTimeout: time.Hour, min: 0, max: time.Second},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var timeout string
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					timeout = r.Header.Get("grpc-timeout")
+					w.Write(readFile(t, "unary_ktr.out"))
+				}))
+				defer srv.Close()
+
+				ctx := context.Background()
+				if c.ctxTimeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, c.ctxTimeout)
+					defer cancel()
+				}
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithCallTimeout(c.callTimeout))
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(ctx, NewRequest(endpoint, in, out))
+				require.NoError(t, err)
+
+				require.NotEmpty(t, timeout)
+				v, err := strconv.ParseInt(timeout[:len(timeout)-1], 10, 64)
+				require.NoError(t, err)
+				d := time.Duration(v) * units[timeout[len(timeout)-1]]
+				assert.True(t, c.min < d && d <= c.max, "grpc-timeout: %s", timeout)
+			})
+		}
+
+		t.Run("the call fails after the timeout", func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the cancellation is detected after the body is read.
+				ioutil.ReadAll(r.Body)
+				<-r.Context().Done()
+			}))
+			defer srv.Close()
+
+			client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithCallTimeout(50*time.Millisecond))
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("server streaming over HTTP reads flushed frames incrementally", func(t *testing.T) {
+		msgs := []string{"foo", "bar", "baz"}
+		received := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			for _, m := range msgs {
+				b, err := proto.Marshal(&wrappers.StringValue{Value: m})
+				require.NoError(t, err)
+				w.Write(frame(0x00, b))
+				w.(http.Flusher).Flush()
+
+				// the next frame is written only after the client receives the flushed one.
+				select {
+				case <-received:
+				case <-time.After(5 * time.Second):
+					t.Error("the client didn't receive a flushed frame")
+					return
+				}
+			}
+			w.Write(frame(0x80, []byte("grpc-status: 0\r\n")))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		out := &wrappers.StringValue{}
+		stream, err := client.ServerStreaming(context.Background(), NewRequest("/api.Example/ServerStreaming", &wrappers.StringValue{}, out))
+		require.NoError(t, err)
+
+		for _, m := range msgs {
+			_, err := stream.Receive()
+			require.NoError(t, err)
+			assert.Equal(t, m, out.GetValue())
+			received <- struct{}{}
+		}
+		_, err = stream.Receive()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("te header", func(t *testing.T) {
+		cases := map[string]struct {
+			opts []ClientOption
+			te   string
+		}{
+			"default":  {te: "trailers"},
+			"disabled": {opts: []ClientOption{WithDisableTETrailers()}},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var header http.Header
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					header = r.Header
+				}))
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.opts, WithInsecure())...)
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				client.Unary(context.Background(), NewRequest(endpoint, in, out))
+
+				assert.Equal(t, c.te, header.Get("te"))
+			})
+		}
+	})
+
+	t.Run("content-type and x-grpc-web headers", func(t *testing.T) {
+		cases := map[string]struct {
+			opts                []ClientOption
+			contentType, xgrpcw string
+		}{
+			"default":              {contentType: "application/grpc-web+proto", xgrpcw: "1"},
+			"derived from codec":   {opts: []ClientOption{WithCodec(&stubCodec{name: "json"})}, contentType: "application/grpc-web+json", xgrpcw: "1"},
+			"overridden":           {opts: []ClientOption{WithContentType("application/grpc-web"), WithXGRPCWebHeader("2")}, contentType: "application/grpc-web", xgrpcw: "2"},
+			"codec and overridden": {opts: []ClientOption{WithCodec(&stubCodec{name: "json"}), WithContentType("application/grpc-web")}, contentType: "application/grpc-web", xgrpcw: "1"},
+			"disabled":             {opts: []ClientOption{WithXGRPCWebHeader("2"), WithDisableXGRPCWebHeader()}, contentType: "application/grpc-web+proto"},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var header http.Header
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					header = r.Header
+				}))
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.opts, WithInsecure())...)
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				client.Unary(context.Background(), NewRequest(endpoint, in, out))
+
+				assert.Equal(t, c.contentType, header.Get("content-type"))
+				assert.Equal(t, c.xgrpcw, header.Get("x-grpc-web"))
+			})
+		}
+	})
+
+	t.Run("non-200 HTTP status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>bad gateway</html>"))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.Error(t, err)
+
+		herr, ok := pkgerrors.Cause(err).(*HTTPStatusError)
+		require.True(t, ok, "expected *HTTPStatusError, but got %T", pkgerrors.Cause(err))
+		assert.Equal(t, http.StatusBadGateway, herr.StatusCode)
+		assert.Equal(t, "<html>bad gateway</html>", string(herr.Body))
+		assert.Contains(t, err.Error(), "502")
+	})
+
+	t.Run("WithErrorMapper", func(t *testing.T) {
+		// the gateway reports errors as JSON bodies.
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/json")
+			if r.Header.Get("authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"code": 16, "message": "missing token"}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("not a JSON"))
+		}))
+		defer srv.Close()
+
+		mapper := func(res *http.Response, body []byte) error {
+			var e struct {
+				Code    codes.Code `json:"code"`
+				Message string     `json:"message"`
+			}
+			if err := json.Unmarshal(body, &e); err != nil {
+				return nil
+			}
+			return status.Error(e.Code, e.Message)
+		}
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithErrorMapper(mapper))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), "%v", err)
+		assert.Equal(t, "missing token", status.Convert(err).Message())
+
+		t.Run("the default error is returned if the mapper returns nil", func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "token")
+			client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithErrorMapper(mapper), WithContextMetadata())
+			_, err := client.Unary(ctx, NewRequest(endpoint, in, out))
+			herr, ok := pkgerrors.Cause(err).(*HTTPStatusError)
+			require.True(t, ok, "expected *HTTPStatusError, but got %T", pkgerrors.Cause(err))
+			assert.Equal(t, http.StatusBadGateway, herr.StatusCode)
+			assert.Equal(t, "not a JSON", string(herr.Body))
+		})
+	})
+
+	t.Run("grpc-web-text", func(t *testing.T) {
+		var header http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			b, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, r.Body))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, msg, err := readFrame(bytes.NewReader(b))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// the message and the trailer are encoded separately, like some gateways do.
+			w.Header().Set("content-type", "application/grpc-web-text+proto")
+			io.WriteString(w, base64.StdEncoding.EncodeToString(frame(0x00, msg)))
+			io.WriteString(w, base64.StdEncoding.EncodeToString(frame(0x80, []byte("grpc-status: 0\r\n"))))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithContentType("application/grpc-web-text+proto"))
+		out := &wrappers.StringValue{}
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, &wrappers.StringValue{Value: "hello"}, out))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", out.GetValue())
+		assert.Equal(t, "application/grpc-web-text+proto", header.Get("content-type"))
+		assert.Equal(t, "application/grpc-web-text+proto", header.Get("accept"))
+	})
+
+	t.Run("unexpected content-type", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>index</html>"))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.Error(t, err)
+
+		cerr, ok := pkgerrors.Cause(err).(*ContentTypeError)
+		require.True(t, ok, "expected *ContentTypeError, but got %T", pkgerrors.Cause(err))
+		assert.Equal(t, "text/html; charset=utf-8", cerr.ContentType)
+		assert.Equal(t, "<html>index</html>", string(cerr.Body))
+		assert.Contains(t, err.Error(), "text/html")
+
+		cases := map[string]struct {
+			contentType string
+			wantErr     bool
+		}{
+			"JSON":         {contentType: "application/json", wantErr: true},
+			"absent":       {contentType: ""},
+			"octet-stream": {contentType: "application/octet-stream"},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					// an empty content-type disables sniffing of net/http, so the header is absent.
+					w.Header()["Content-Type"] = nil
+					if c.contentType != "" {
+						w.Header().Set("content-type", c.contentType)
+					}
+					w.Write(readFile(t, "unary_ktr.out"))
+				}))
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+				if c.wantErr {
+					_, ok := pkgerrors.Cause(err).(*ContentTypeError)
+					assert.True(t, ok, "expected *ContentTypeError, but got %v", err)
+					return
+				}
+				assert.NoError(t, err)
+			})
+		}
+	})
+
+	t.Run("gRPC over HTTP/2", func(t *testing.T) {
+		message := readFile(t, "unary_ktr.out")[:headerLen+12]
+
+		cases := map[string]struct {
+			handler func(w http.ResponseWriter)
+			code    codes.Code
+		}{
+			"status in trailers": {
+				handler: func(w http.ResponseWriter) {
+					w.Header().Set("trailer", "grpc-status, grpc-message")
+					w.Write(message)
+					w.Header().Set("grpc-status", "5")
+					w.Header().Set("grpc-message", "not%20found")
+				},
+				code: codes.NotFound,
+			},
+			"trailers-only": {
+				handler: func(w http.ResponseWriter) {
+					w.Header().Set("grpc-status", "5")
+					w.Header().Set("grpc-message", "not%20found")
+				},
+				code: codes.NotFound,
+			},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var proto int
+				var header http.Header
+				srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					proto, header = r.ProtoMajor, r.Header
+					w.Header().Set("content-type", "application/grpc+proto")
+					c.handler(w)
+				}))
+				srv.TLS = &tls.Config{NextProtos: []string{"h2"}}
+				srv.StartTLS()
+				defer srv.Close()
+
+				pool := x509.NewCertPool()
+				pool.AddCert(srv.Certificate())
+				client := NewClient(strings.TrimPrefix(srv.URL, "https://"), WithContentType("application/grpc+proto"), WithTLSConfig(&tls.Config{RootCAs: pool}))
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+				assert.Equal(t, c.code, status.Code(err), "%v", err)
+				assert.Equal(t, "not found", status.Convert(err).Message())
+
+				assert.Equal(t, 2, proto)
+				assert.Equal(t, "application/grpc+proto", header.Get("content-type"))
+				assert.Equal(t, "trailers", header.Get("te"))
+				assert.Empty(t, header.Get("x-grpc-web"))
+			})
+		}
+	})
+
+	t.Run("WithRoundTripper", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("connect-protocol-version") != "" {
+				// the Connect unary protocol sends the message without the framing.
+				_, msg, _ := NewFrameReader(bytes.NewReader(readFile(t, "unary_ktr.out"))).Next()
+				w.Header().Set("content-type", "application/proto")
+				w.Write(msg)
+				return
+			}
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			w.Write(readFile(t, "unary_ktr.out"))
+		}))
+		defer srv.Close()
+
+		rt := &countingRoundTripper{rt: http.DefaultTransport}
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithRoundTripper(rt))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&rt.n))
+
+		// other HTTP requests of the client also go through rt.
+		_, err = Dial(context.Background(), strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithRoundTripper(rt))
+		require.NoError(t, err)
+		client = NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithRoundTripper(rt), WithTransportBuilderWithOptions(ConnectTransportBuilder))
+		res, err = client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&rt.n))
+	})
+
+	t.Run("WithHTTP2", func(t *testing.T) {
+		cases := map[string]struct {
+			opts  []ClientOption
+			proto int
+		}{
+			"default":   {proto: 1},
+			"WithHTTP2": {opts: []ClientOption{WithHTTP2()}, proto: 2},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var proto int
+				srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					proto = r.ProtoMajor
+					w.Header().Set("content-type", "application/grpc-web+proto")
+					w.Write(readFile(t, "unary_ktr.out"))
+				}))
+				srv.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+				srv.StartTLS()
+				defer srv.Close()
+
+				pool := x509.NewCertPool()
+				pool.AddCert(srv.Certificate())
+				client := NewClient(strings.TrimPrefix(srv.URL, "https://"), append(c.opts, WithTLSConfig(&tls.Config{RootCAs: pool}))...)
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+				require.NoError(t, err)
+				assert.Equal(t, c.proto, proto)
+			})
+		}
+	})
+
+	t.Run("Close closes idle connections", func(t *testing.T) {
+		closed := make(chan struct{})
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(readFile(t, "unary_ktr.out"))
+		}))
+		srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+			if s == http.StateClosed {
+				close(closed)
+			}
+		}
+		srv.Start()
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		require.NoError(t, client.Close())
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Error("the idle connection was not closed")
+		}
+	})
+
+	t.Run("Send a server streaming API", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: readFile(t, "server_ktr.out"),
+		}, nil))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		req := NewRequest(endpoint, in, out)
+		s, err := client.ServerStreaming(context.Background(), req)
+		assert.NoError(t, err)
+
+		for i := 0; ; i++ {
+			res, err := s.Receive()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			expected := fmt.Sprintf("hello ktr, I greet %d times.", i)
+			assert.Equal(t, expected, extractMessage(t, res))
+		}
+	})
+
+	t.Run("server streaming detects the end by the trailer frame", func(t *testing.T) {
+		// the first byte of the message is the tag of an unknown field 2, not a compressed-flag.
+		body := []byte{0x12, 0x01, 'x', 0x0a, 0x03, 'k', 't', 'r'}
+		cases := map[string]struct {
+			trailer string
+			code    codes.Code
+		}{
+			"OK":     {trailer: "grpc-status: 0\r\n", code: codes.OK},
+			"non-OK": {trailer: "grpc-status: 13\r\ngrpc-message: broken\r\n", code: codes.Internal},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				var res []byte
+				res = append(res, frame(0x00, nil)...)
+				res = append(res, frame(0x00, body)...)
+				res = append(res, frame(0x80, []byte(c.trailer))...)
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: res}, nil))
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+				require.NoError(t, err)
+
+				var messages []string
+				for {
+					res, err := s.Receive()
+					if err != nil {
+						if c.code == codes.OK {
+							assert.Equal(t, io.EOF, err)
+						} else {
+							assert.Equal(t, c.code, status.Code(err))
+						}
+						break
+					}
+					messages = append(messages, extractMessage(t, res))
+				}
+				assert.Equal(t, []string{"", "ktr"}, messages)
+			})
+		}
+	})
+
+	t.Run("server streaming ends with exactly io.EOF", func(t *testing.T) {
+		message := readFile(t, "unary_ktr.out")[:headerLen+12]
+		cases := map[string]struct {
+			res []byte
+			eof bool
+		}{
+			"trailer":                  {res: append(append([]byte(nil), message...), frame(0x80, []byte("grpc-status: 0\r\n"))...), eof: true},
+			"frames after the trailer": {res: append(frame(0x80, []byte("grpc-status: 0\r\n")), message...), eof: true},
+			"no trailer":               {res: message, eof: true},
+			"truncated":                {res: message[:headerLen+1]},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: c.res}, nil))
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+				require.NoError(t, err)
+
+				var err1 error
+				for err1 == nil {
+					_, err1 = s.Receive()
+				}
+				if !c.eof {
+					assert.NotEqual(t, io.EOF, err1)
+					assert.NotEqual(t, io.EOF, pkgerrors.Cause(err1))
+					return
+				}
+				assert.Equal(t, io.EOF, err1)
+
+				_, err2 := s.Receive()
+				assert.Equal(t, io.EOF, err2, "Receive must keep returning io.EOF after the end")
+			})
+		}
+	})
+
+	t.Run("WithMaxConcurrentStreams", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{}, &stubStreamTransport{
+			res: frame(0x80, []byte("grpc-status: 0\r\n")),
+		}), WithMaxConcurrentStreams(1))
+		req := NewRequest("/api.Example/BidiStreaming", &wrappers.StringValue{}, &wrappers.StringValue{})
+
+		s, err := client.BidiStreaming(context.Background(), req)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = client.BidiStreaming(ctx, req)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "the stream must wait for a slot")
+
+		// a stream which waits for a slot opens once another stream is closed.
+		opened := make(chan error)
+		go func() {
+			cs, err := client.ClientStreaming(context.Background())
+			if err == nil {
+				err = cs.Send(NewRequest("/api.Example/ClientStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}))
+			}
+			if err == nil {
+				_, err = cs.CloseAndReceive()
+			}
+			opened <- err
+		}()
+		require.NoError(t, s.Close())
+		select {
+		case err := <-opened:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the slot was not released on Close")
+		}
+
+		// CloseAndReceive also releases the slot.
+		s, err = client.BidiStreaming(context.Background(), req)
+		require.NoError(t, err)
+		s.Close()
+	})
+
+	t.Run("CloseStreams", func(t *testing.T) {
+		message := readFile(t, "unary_ktr.out")[:headerLen+12]
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			w.Write(message)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer srv.Close()
+
+		st := &blockingStreamTransport{closed: make(chan struct{})}
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithStreamTransportBuilderWithOptions(
+			func(context.Context, string, string, *TransportOptions) (StreamTransport, error) {
+				return st, nil
+			}))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		ss, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		_, err = ss.Receive()
+		require.NoError(t, err)
+
+		bs, err := client.BidiStreaming(context.Background(), NewRequest("/api.Example/BidiStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}))
+		require.NoError(t, err)
+
+		client.CloseStreams()
+
+		_, err = ss.Receive()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		_, err = bs.Receive()
+		assert.Error(t, err)
+		assert.Equal(t, 1, st.n, "the stream transport must be closed once")
+
+		client.CloseStreams()
+		assert.Equal(t, 1, st.n, "closed streams must not be tracked")
+	})
+
+	t.Run("WithStreamReconnect re-sends the request on disconnection", func(t *testing.T) {
+		message := readFile(t, "unary_ktr.out")[:headerLen+12]
+		newServer := func() *httptest.Server {
+			var n int32
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := atomic.AddInt32(&n, 1)
+				w.Header().Set("x-attempt", fmt.Sprint(attempt))
+				if attempt == 1 {
+					// the connection is closed in the middle of the second frame.
+					w.Header().Set("content-length", "100")
+					w.Write(message)
+					w.Write(message[:3])
+					return
+				}
+				w.Write(message)
+				w.Write(frame(0x80, []byte("grpc-status: 0\r\n")))
+			}))
+		}
+
+		cases := map[string]struct {
+			opts     []ClientOption
+			messages int
+			wantErr  bool
+			// attempt is the header of the last connection.
+			attempt string
+		}{
+			"disabled": {messages: 1, wantErr: true, attempt: "1"},
+			"enabled":  {opts: []ClientOption{WithStreamReconnect(ReconnectPolicy{MaxAttempts: 1})}, messages: 2, attempt: "2"},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				srv := newServer()
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.opts, WithInsecure())...)
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				var header metadata.MD
+				s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out), Header(&header))
+				require.NoError(t, err)
+
+				var messages int
+				for {
+					res, err := s.Receive()
+					if err == io.EOF {
+						assert.False(t, c.wantErr)
+						break
+					}
+					if err != nil {
+						assert.True(t, c.wantErr, "%v", err)
+						break
+					}
+					assert.Equal(t, "hello, ktr", extractMessage(t, res))
+					messages++
+				}
+				assert.Equal(t, c.messages, messages)
+				assert.Equal(t, []string{c.attempt}, header.Get("x-attempt"))
+			})
+		}
+
+		t.Run("malformed frames are not retried", func(t *testing.T) {
+			var n int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&n, 1)
+				// a compressed frame, but the response has no grpc-encoding.
+				w.Write(frame(flagCompressed, []byte("foo")))
+			}))
+			defer srv.Close()
+
+			client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithStreamReconnect(ReconnectPolicy{MaxAttempts: 3}))
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+			require.NoError(t, err)
+
+			_, err = s.Receive()
+			assert.Equal(t, codes.Internal, status.Code(pkgerrors.Cause(err)), "%v", err)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+		})
+	})
+
+	t.Run("Cancel stops a server stream", func(t *testing.T) {
+		canceled := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(readFile(t, "unary_ktr.out")[:headerLen+12])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			close(canceled)
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		res, err := s.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+
+		s.(ServerStream).Cancel()
+		select {
+		case <-canceled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the server was not notified of the cancellation")
+		}
+
+		_, err = s.Receive()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+
+	t.Run("ReceiveChan delivers server stream responses", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: readFile(t, "server_ktr.out"),
+		}, nil))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		resc, errc := s.(ServerStream).ReceiveChan()
+		var responses []*Response
+		for res := range resc {
+			responses = append(responses, res)
+		}
+		assert.NoError(t, <-errc)
+
+		require.NotEmpty(t, responses)
+		for i, res := range responses {
+			expected := fmt.Sprintf("hello ktr, I greet %d times.", i)
+			assert.Equal(t, expected, extractMessage(t, res))
+		}
+	})
+
+	t.Run("ReceiveChan exits when the context is canceled", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: readFile(t, "server_ktr.out"),
+		}, nil))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		s, err := client.ServerStreaming(ctx, NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		resc, errc := s.(ServerStream).ReceiveChan()
+		cancel()
+
+		select {
+		case err := <-errc:
+			assert.Equal(t, codes.Canceled, status.Code(err))
+		case <-time.After(5 * time.Second):
+			t.Fatal("the goroutine didn't exit")
+		}
+		for range resc {
+		}
+	})
+
+	t.Run("WithStreamMetadataCallback receives metadata frames in a server stream", func(t *testing.T) {
+		// each message frame of server_ktr.out has 34 bytes.
+		messages := readFile(t, "server_ktr.out")
+		var res []byte
+		res = append(res, messages[:34]...)
+		res = append(res, frame(0x80, []byte("x-progress: 50\r\n"))...)
+		res = append(res, messages[34:68]...)
+		res = append(res, frame(0x80, []byte("grpc-status: 0\r\n"))...)
+
+		var mds []metadata.MD
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: res}, nil), WithStreamMetadataCallback(func(md metadata.MD) {
+			mds = append(mds, md)
+		}))
+
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		s, err := client.ServerStreaming(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+
+		var n int
+		for ; ; n++ {
+			_, err := s.Receive()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []metadata.MD{{"x-progress": []string{"50"}}}, mds)
+	})
+
+	t.Run("RoundTrip sends and receives raw frames", func(t *testing.T) {
+		res := readFile(t, "server_ktr.out")
+		st := &stubTransport{
+			header: metadata.Pairs("content-type", "application/grpc-web+proto"),
+			res:    append(res[:68:68], frame(0x80, []byte("grpc-status: 0\r\n"))...),
+		}
+		client := NewClient(defaultAddr, withStubTransport(st, nil))
+
+		reqFrames := [][]byte{frame(0, []byte("foo")), frame(0, []byte("bar"))}
+		resFrames, header, s, err := client.RoundTrip(context.Background(), endpoint, reqFrames)
+		require.NoError(t, err)
+
+		assert.Equal(t, bytes.Join(reqFrames, nil), st.sent)
+		assert.Equal(t, endpoint, st.req.endpoint)
+		assert.Equal(t, [][]byte{res[:34], res[34:68]}, resFrames)
+		assert.Equal(t, st.header, header)
+		assert.Equal(t, codes.OK, s.Code())
+
+		t.Run("non-OK status is not an error", func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+				res: frame(0x80, []byte("grpc-status: 5\r\n")),
+			}, nil))
+
+			resFrames, _, s, err := client.RoundTrip(context.Background(), endpoint, reqFrames)
+			require.NoError(t, err)
+			assert.Empty(t, resFrames)
+			assert.Equal(t, codes.NotFound, s.Code())
+		})
+	})
+
+	t.Run("CloseAndReceive reads the trailer even if the server closes promptly", func(t *testing.T) {
+		cases := map[string]struct {
+			trailer string
+			code    codes.Code
+		}{
+			"OK":        {trailer: "grpc-status: 0\r\n", code: codes.OK},
+			"not found": {trailer: "grpc-status: 5\r\ngrpc-message: not%20found\r\n", code: codes.NotFound},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				message := readFile(t, "unary_ktr.out")[:headerLen+12]
+				trailer := frame(0x80, []byte(c.trailer))
+				srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+					// wait for the EOF request.
+					for {
+						_, b, err := conn.ReadMessage()
+						if err != nil {
+							return
+						}
+						if len(b) == 1 && b[0] == 0x01 {
+							break
+						}
+					}
+					// response headers, a message and the trailer.
+					// each frame is sent as a header and its content.
+					for _, b := range [][]byte{[]byte("header"), []byte("\r\n"), message[:headerLen], message[headerLen:], trailer[:headerLen], trailer[headerLen:]} {
+						conn.WriteMessage(websocket.BinaryMessage, b)
+					}
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				})
+				defer srv.Close()
+
+				client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+				s, err := client.ClientStreaming(context.Background())
+				require.NoError(t, err)
+
+				in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+				require.NoError(t, s.Send(NewRequest(endpoint, in, out)))
+
+				res, err := s.CloseAndReceive()
+				require.Equal(t, c.code, status.Code(err), "%v", err)
+				if c.code == codes.OK {
+					assert.Equal(t, "hello, ktr", extractMessage(t, res))
+				}
+			})
+		}
+	})
+
+	t.Run("WithHTTPClientStreaming sends requests in one body", func(t *testing.T) {
+		st := &stubTransport{res: readFile(t, "unary_ktr.out")}
+		client := NewClient(defaultAddr, withStubTransport(st, nil), WithHTTPClientStreaming())
+
+		cs, err := client.ClientStreaming(context.Background())
+		require.NoError(t, err)
+
+		var expected []byte
+		for _, name := range []string{"foo", "bar"} {
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			in.SetFieldByName("name", name)
+			b, err := in.Marshal()
+			require.NoError(t, err)
+			expected = append(expected, frame(0x00, b)...)
+
+			require.NoError(t, cs.Send(NewMethodRequest("api", service, service.GetMethod()[9], in, out)))
+		}
+		assert.Nil(t, st.sent, "requests must be buffered until CloseAndReceive")
+
+		res, err := cs.CloseAndReceive()
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+		assert.Equal(t, expected, st.sent)
+	})
+
+	t.Run("Flush streams requests of WithHTTPClientStreaming before CloseAndReceive", func(t *testing.T) {
+		received := make(chan []byte)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for {
+				_, b, err := readFrame(r.Body)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				received <- b
+			}
+			close(received)
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			w.Write(readFile(t, "unary_ktr.out"))
+		}))
+		defer srv.Close()
+
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithHTTPClientStreaming())
+		cs, err := client.ClientStreaming(context.Background())
+		require.NoError(t, err)
+
+		for _, name := range []string{"foo", "bar"} {
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			in.SetFieldByName("name", name)
+			b, err := in.Marshal()
+			require.NoError(t, err)
+
+			require.NoError(t, cs.Send(NewMethodRequest("api", service, service.GetMethod()[9], in, out)))
+			require.NoError(t, cs.(ClientStream).Flush())
+			select {
+			case got := <-received:
+				assert.Equal(t, b, got)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the flushed request didn't reach the server")
+			}
+		}
 
-type stubStreamTransport struct {
-	res []byte
-}
+		res, err := cs.CloseAndReceive()
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
 
-func (b *stubStreamTransport) Send(body io.Reader) error {
-	return nil
-}
+		t.Run("the server responds before the body ends", func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer srv.Close()
 
-func (b *stubStreamTransport) Receive() (io.ReadCloser, error) {
-	return ioutil.NopCloser(bytes.NewReader(b.res)), nil
-}
+			client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithHTTPClientStreaming())
+			cs, err := client.ClientStreaming(context.Background())
+			require.NoError(t, err)
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			require.NoError(t, cs.Send(NewMethodRequest("api", service, service.GetMethod()[9], in, out)))
+			cs.(ClientStream).Flush()
+			require.NoError(t, cs.Send(NewMethodRequest("api", service, service.GetMethod()[9], in, out)))
+
+			_, err = cs.CloseAndReceive()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "503")
+		})
+
+		t.Run("grpc-web-text", func(t *testing.T) {
+			client := NewClient(defaultAddr, WithContentType("application/grpc-web-text"), WithHTTPClientStreaming())
+			cs, err := client.ClientStreaming(context.Background())
+			require.NoError(t, err)
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			require.NoError(t, cs.Send(NewMethodRequest("api", service, service.GetMethod()[9], in, out)))
+			assert.Equal(t, codes.Unimplemented, status.Code(cs.(ClientStream).Flush()))
+		})
+	})
 
-func (b *stubStreamTransport) Finish() (io.ReadCloser, error) {
-	return ioutil.NopCloser(bytes.NewReader(b.res)), nil
-}
+	t.Run("Flush of the stream transport does nothing", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(nil, &stubStreamTransport{}))
+		cs, err := client.ClientStreaming(context.Background())
+		require.NoError(t, err)
+		assert.NoError(t, cs.(ClientStream).Flush())
+	})
 
-func (b *stubStreamTransport) Close() error {
-	return nil
+	t.Run("mismatched API kind", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: readFile(t, "unary_ktr.out"),
+		}, &stubStreamTransport{}))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		serverStreaming := NewMethodRequest("api", service, service.GetMethod()[10], in, out)
+
+		_, err := client.Unary(context.Background(), serverStreaming)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is a server streaming API, but it is called as a unary API")
+
+		_, err = client.ServerStreaming(context.Background(), NewMethodRequest("api", service, service.GetMethod()[0], in, out))
+		assert.Error(t, err)
+
+		_, err = client.BidiStreaming(context.Background(), serverStreaming)
+		assert.Error(t, err)
+
+		cs, err := client.ClientStreaming(context.Background())
+		require.NoError(t, err)
+		assert.Error(t, cs.Send(serverStreaming))
+	})
+
+	t.Run("Invoke dispatches by the API kind", func(t *testing.T) {
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+
+		t.Run("unary", func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+				res: readFile(t, "unary_ktr.out"),
+			}, nil))
+
+			req := NewMethodRequest("api", service, service.GetMethod()[0], in, out)
+			res, err := client.Invoke(context.Background(), req)
+			require.NoError(t, err)
+			require.IsType(t, &Response{}, res)
+			assert.Equal(t, "hello, ktr", extractMessage(t, res.(*Response)))
+		})
+
+		cases := map[string]struct {
+			method   int
+			expected interface{}
+		}{
+			"server streaming": {method: 10, expected: (*ServerStreamClient)(nil)},
+			"client streaming": {method: 9, expected: (*ClientStreamClient)(nil)},
+			"bidi streaming":   {method: 11, expected: (*BidiStreamClient)(nil)},
+		}
+		for name, c := range cases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+					res: readFile(t, "server_ktr.out"),
+				}, &stubStreamTransport{}))
+
+				req := NewMethodRequest("api", service, service.GetMethod()[c.method], in, out)
+				s, err := client.Invoke(context.Background(), req)
+				require.NoError(t, err)
+				assert.Implements(t, c.expected, s)
+			})
+		}
+
+		t.Run("request without method descriptor", func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{}, nil))
+
+			_, err := client.Invoke(context.Background(), NewRequest(endpoint, in, out))
+			assert.Error(t, err)
+		})
+	})
 }
 
-// for testing
-func withStubTransport(t *stubTransport, st *stubStreamTransport) ClientOption {
-	stubBuilder := func(host string, req *Request) Transport {
-		t.host = host
-		t.req = req
-		return t
-	}
-	stubStreamBuilder := func(host string, endpoint string) (StreamTransport, error) {
-		return st, nil
-	}
-	return func(c *Client) {
-		c.tb = stubBuilder
-		c.stb = stubStreamBuilder
-	}
+func TestDial(t *testing.T) {
+	t.Run("reachable", func(t *testing.T) {
+		var method, path string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.Path
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		client, err := Dial(context.Background(), strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithBasePath("/grpc"))
+		require.NoError(t, err)
+		defer client.Close()
+		assert.Equal(t, http.MethodHead, method)
+		assert.Equal(t, "/grpc/", path)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		_, err = Dial(context.Background(), addr, WithInsecure())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to connect to "+addr)
+	})
+
+	t.Run("TLS error", func(t *testing.T) {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+
+		// the certificate of the server is not trusted.
+		_, err := Dial(context.Background(), strings.TrimPrefix(srv.URL, "https://"))
+		assert.Error(t, err)
+	})
 }
 
-func TestClient(t *testing.T) {
+func TestClientTransportSecurity(t *testing.T) {
 	pkg := getAPIProto(t)
 	service := pkg.getServiceByName(t, "Example")
 	endpoint := ToEndpoint("api", service, service.GetMethod()[0])
 
-	t.Run("ToEndpoint", func(t *testing.T) {
-		expected := fmt.Sprintf("/api.%s/%s", service.GetName(), service.GetMethod()[0].GetName())
-		assert.Equal(t, expected, endpoint)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(readFile(t, "unary_ktr.out"))
 	})
+	httpSrv := httptest.NewServer(handler)
+	defer httpSrv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsSrv.Certificate())
+
+	httpHost := strings.TrimPrefix(httpSrv.URL, "http://")
+	tlsHost := strings.TrimPrefix(tlsSrv.URL, "https://")
+
+	cases := map[string]struct {
+		host    string
+		opts    []ClientOption
+		wantErr bool
+	}{
+		"insecure to HTTP":              {host: httpHost, opts: []ClientOption{WithInsecure()}},
+		"secure to TLS":                 {host: tlsHost, opts: []ClientOption{WithTLSConfig(&tls.Config{RootCAs: pool})}},
+		"insecure overrides TLS config": {host: httpHost, opts: []ClientOption{WithTLSConfig(&tls.Config{RootCAs: pool}), WithInsecure()}},
+		"secure to HTTP":                {host: httpHost, opts: []ClientOption{WithTLSConfig(&tls.Config{RootCAs: pool})}, wantErr: true},
+		"insecure to TLS":               {host: tlsHost, opts: []ClientOption{WithInsecure()}, wantErr: true},
+		"skip verify to TLS":            {host: tlsHost, opts: []ClientOption{WithInsecureSkipVerify()}},
+		"skip verify with TLS config":   {host: tlsHost, opts: []ClientOption{WithInsecureSkipVerify(), WithTLSConfig(&tls.Config{})}},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			client := NewClient(c.host, c.opts...)
+			defer client.Close()
+
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			if c.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "hello, ktr", extractMessage(t, res))
+		})
+	}
 
-	t.Run("NewClient returns new API client", func(t *testing.T) {
-		client := NewClient(defaultAddr, withStubTransport(&stubTransport{}, nil))
-		assert.NotNil(t, client)
+	t.Run("Peer has the TLS info", func(t *testing.T) {
+		client := NewClient(tlsHost, WithTLSConfig(&tls.Config{RootCAs: pool}))
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+
+		var p peer.Peer
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out), Peer(&p))
+		require.NoError(t, err)
+		assert.Equal(t, tlsSrv.Listener.Addr().String(), p.Addr.String())
+		require.IsType(t, credentials.TLSInfo{}, p.AuthInfo)
+		assert.True(t, p.AuthInfo.(credentials.TLSInfo).State.HandshakeComplete)
 	})
 
-	t.Run("Send an unary API", func(t *testing.T) {
-		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
-			res: readFile(t, "unary_ktr.out"),
-		}, nil))
+	t.Run("certificate verification failure", func(t *testing.T) {
+		client := NewClient(tlsHost)
+		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+		_, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "x509: certificate signed by unknown authority")
+	})
 
+	t.Run("TransportBuilder speaks plaintext HTTP", func(t *testing.T) {
+		// TLS options are not passed to builders without TransportOptions.
+		client := NewClient(httpHost, WithTLSConfig(&tls.Config{RootCAs: pool}), WithTransportBuilder(HTTPTransportBuilder))
 		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
-		req := NewRequest(endpoint, in, out)
-		res, err := client.Unary(context.Background(), req)
-		assert.NoError(t, err)
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
 		assert.Equal(t, "hello, ktr", extractMessage(t, res))
 	})
 
-	t.Run("Send a server streaming API", func(t *testing.T) {
-		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
-			res: readFile(t, "server_ktr.out"),
-		}, nil))
-
+	t.Run("TransportBuilderWithOptions receives the TLS config", func(t *testing.T) {
+		cfg := &tls.Config{RootCAs: pool}
+		var got *TransportOptions
+		client := NewClient(tlsHost, WithTLSConfig(cfg), WithTransportBuilderWithOptions(func(host string, req *Request, opts *TransportOptions) Transport {
+			got = opts
+			return HTTPTransportBuilderWithOptions(host, req, opts)
+		}))
 		in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
-		req := NewRequest(endpoint, in, out)
-		s, err := client.ServerStreaming(context.Background(), req)
-		assert.NoError(t, err)
+		res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+		require.NoError(t, err)
+		assert.Equal(t, "hello, ktr", extractMessage(t, res))
+		require.NotNil(t, got)
+		assert.False(t, got.Insecure)
+		assert.Equal(t, cfg, got.TLSConfig)
+	})
 
-		for i := 0; ; i++ {
-			res, err := s.Receive()
-			if err == io.EOF {
-				break
+	t.Run("WebSocket", func(t *testing.T) {
+		upgrader := websocket.Upgrader{
+			Subprotocols: []string{"grpc-websockets"},
+		}
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
 			}
-			require.NoError(t, err)
+			conn.Close()
+		}))
+		defer srv.Close()
 
-			expected := fmt.Sprintf("hello ktr, I greet %d times.", i)
-			assert.Equal(t, expected, extractMessage(t, res))
-		}
+		pool := x509.NewCertPool()
+		pool.AddCert(srv.Certificate())
+		host := strings.TrimPrefix(srv.URL, "https://")
+
+		tr, err := WebSocketTransportBuilderWithOptions(context.Background(), host, endpoint, &TransportOptions{TLSConfig: &tls.Config{RootCAs: pool}})
+		require.NoError(t, err)
+		tr.Close()
+
+		_, err = WebSocketTransportBuilderWithOptions(context.Background(), host, endpoint, &TransportOptions{})
+		assert.Error(t, err, "the certificate must not be trusted")
+
+		tr, err = WebSocketTransportBuilderWithOptions(context.Background(), host, endpoint, &TransportOptions{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		tr.Close()
+
+		_, err = WebSocketTransportBuilderWithOptions(context.Background(), host, endpoint, &TransportOptions{Insecure: true})
+		assert.Error(t, err, "ws must not be accepted by the TLS server")
 	})
 }
 
@@ -179,7 +2307,7 @@ func TestClientE2E(t *testing.T) {
 	t.Run("Unary", func(t *testing.T) {
 		defer server.New(false).Serve(nil, true).Stop()
 
-		client := NewClient(defaultAddr)
+		client := NewClient(defaultAddr, WithInsecure())
 		endpoint := ToEndpoint("api", service, service.GetMethod()[0])
 
 		in := pkg.getMessageTypeByName(t, "SimpleRequest")
@@ -206,7 +2334,7 @@ func TestClientE2E(t *testing.T) {
 	t.Run("ServerStreaming", func(t *testing.T) {
 		defer server.New(false).Serve(nil, true).Stop()
 
-		client := NewClient(defaultAddr)
+		client := NewClient(defaultAddr, WithInsecure())
 		endpoint := ToEndpoint("api", service, service.GetMethod()[10])
 		assert.Equal(t, endpoint, "/api.Example/ServerStreaming")
 
@@ -234,7 +2362,7 @@ func TestClientE2E(t *testing.T) {
 	t.Run("ClientStreaming", func(t *testing.T) {
 		defer server.New(false).Serve(nil, true).Stop()
 
-		client := NewClient(defaultAddr)
+		client := NewClient(defaultAddr, WithInsecure())
 		endpoint := ToEndpoint("api", service, service.GetMethod()[9])
 		assert.Equal(t, endpoint, "/api.Example/ClientStreaming")
 
@@ -262,7 +2390,7 @@ func TestClientE2E(t *testing.T) {
 	t.Run("BidiStreaming", func(t *testing.T) {
 		defer server.New(false).Serve(nil, true).Stop()
 
-		client := NewClient(defaultAddr)
+		client := NewClient(defaultAddr, WithInsecure())
 		endpoint := ToEndpoint("api", service, service.GetMethod()[11])
 		assert.Equal(t, endpoint, "/api.Example/BidiStreaming")
 
@@ -278,7 +2406,7 @@ func TestClientE2E(t *testing.T) {
 			defer close(done)
 			for {
 				res, err := s.Receive()
-				if err == ErrConnectionClosed {
+				if err == ErrConnectionClosed || err == io.EOF {
 					return
 				}
 				// TODO: use testing.T
@@ -313,6 +2441,452 @@ func TestClientE2E(t *testing.T) {
 	})
 }
 
+func BenchmarkUnary(b *testing.B) {
+	res := readFile(b, "unary_ktr.out")
+
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write(res)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	pkg := getAPIProto(b)
+	service := pkg.getServiceByName(b, "Example")
+	endpoint := ToEndpoint("api", service, service.GetMethod()[0])
+	in, out := pkg.getMessageTypeByName(b, "SimpleRequest"), pkg.getMessageTypeByName(b, "SimpleResponse")
+
+	client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Unary(context.Background(), NewRequest(endpoint, in, out)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	// all sequential calls must share one connection.
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		b.Errorf("expected 1 connection, but %d connections were established", n)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	b := append(frame(0x00, []byte("foo")), frame(0x80, []byte("bar"))...)
+
+	t.Run("short reads", func(t *testing.T) {
+		r := iotest.OneByteReader(bytes.NewReader(b))
+
+		flag, body, err := readFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x00), flag)
+		assert.Equal(t, "foo", string(body))
+
+		flag, body, err = readFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x80), flag)
+		assert.Equal(t, "bar", string(body))
+
+		_, _, err = readFrame(r)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("buffered body", func(t *testing.T) {
+		// frames are read correctly even if the underlying reader returns a byte at a time.
+		r := newBufferedBody(ioutil.NopCloser(iotest.OneByteReader(bytes.NewReader(b))))
+		for _, expected := range []string{"foo", "bar"} {
+			_, body, err := readFrame(r)
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(body))
+		}
+		_, _, err := readFrame(r)
+		assert.Equal(t, io.EOF, err)
+
+		var many []byte
+		for i := 0; i < 100; i++ {
+			many = append(many, frame(0x00, []byte("foo"))...)
+		}
+		cr := &countingReader{r: bytes.NewReader(many)}
+		r = newBufferedBody(ioutil.NopCloser(cr))
+		for i := 0; i < 100; i++ {
+			_, _, err := readFrame(r)
+			require.NoError(t, err)
+		}
+		assert.True(t, cr.n < 10, "small frames must be read in a few reads, but %d reads", cr.n)
+	})
+
+	t.Run("declared length larger than the content", func(t *testing.T) {
+		h := []byte{0x00, 0x7f, 0xff, 0xff, 0xff}
+		_, _, err := readFrame(bytes.NewReader(append(h, "foo"...)))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("truncated frame", func(t *testing.T) {
+		for _, n := range []int{2, headerLen + 1} {
+			_, _, err := readFrame(bytes.NewReader(b[:n]))
+			assert.Equal(t, io.ErrUnexpectedEOF, err, "%d bytes", n)
+		}
+	})
+}
+
+// countingRoundTripper counts requests sent through rt.
+type countingRoundTripper struct {
+	rt http.RoundTripper
+	n  int32
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.n, 1)
+	return c.rt.RoundTrip(req)
+}
+
+// countingReader counts reads of r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.n++
+	return r.r.Read(p)
+}
+
+func TestFrameFlags(t *testing.T) {
+	b, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+	require.NoError(t, err)
+
+	// the trailer bit takes precedence over the compressed bit, and other bits are ignored.
+	cases := map[string][]byte{
+		"compressed bit in the trailer": append(frame(0x00, b), frame(flagTrailer|flagCompressed, []byte("grpc-status: 0\r\n"))...),
+		"reserved bit in the message":   append(frame(0x02, b), frame(flagTrailer, []byte("grpc-status: 0\r\n"))...),
+	}
+	for name, res := range cases {
+		res := res
+		t.Run(name, func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: res}, nil))
+			out := &wrappers.StringValue{}
+			_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, out))
+			require.NoError(t, err)
+			assert.Equal(t, "foo", out.GetValue())
+		})
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	b := append(frame(0x00, []byte("foo")), frame(0x00, nil)...)
+	b = append(b, frame(0x80, []byte("grpc-status: 0\r\n"))...)
+	fr := NewFrameReader(bytes.NewReader(b))
+
+	expected := []struct {
+		flag    byte
+		payload string
+	}{
+		{0x00, "foo"},
+		{0x00, ""},
+		{0x80, "grpc-status: 0\r\n"},
+	}
+	for _, e := range expected {
+		flag, payload, err := fr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, e.flag, flag)
+		assert.Equal(t, e.payload, string(payload))
+	}
+
+	_, _, err := fr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	t.Run("trailing padding", func(t *testing.T) {
+		b := append(frame(0x00, []byte("foo")), frame(0x80, []byte("metadata: foo\r\n"))...)
+		b = append(b, frame(0x80, []byte("grpc-status: 0\r\n"))...)
+		fr := NewFrameReader(bytes.NewReader(append(b, "\r\n\r\n"...)))
+		for i := 0; i < 3; i++ {
+			_, _, err := fr.Next()
+			require.NoError(t, err)
+		}
+		_, _, err := fr.Next()
+		assert.Equal(t, io.EOF, err, "bytes after the trailer must be ignored")
+	})
+}
+
+func TestTrailingPadding(t *testing.T) {
+	b, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+	require.NoError(t, err)
+	res := append(header(b), b...)
+	res = append(res, TrailerFrame(codes.NotFound, "missing")...)
+	// some gateways pad the end of the body with newlines.
+	res = append(res, "\r\n\r\n"...)
+	req := NewRequest("/api.Example/Method", &wrappers.StringValue{}, &wrappers.StringValue{})
+
+	t.Run("unary", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: res}, nil))
+		_, err := client.Unary(context.Background(), req)
+		assert.Equal(t, codes.NotFound, status.Code(err), "%v", err)
+	})
+
+	t.Run("server streaming", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: res}, nil))
+		stream, err := client.ServerStreaming(context.Background(), req)
+		require.NoError(t, err)
+		_, err = stream.Receive()
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err = stream.Receive()
+			assert.Equal(t, codes.NotFound, status.Code(err), "%v", err)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: res}, nil))
+		frames, _, st, err := client.RoundTrip(context.Background(), "/api.Example/Method", nil)
+		require.NoError(t, err)
+		assert.Len(t, frames, 1)
+		assert.Equal(t, codes.NotFound, st.Code())
+	})
+}
+
+func TestTrailerFrame(t *testing.T) {
+	cases := map[string]struct {
+		code codes.Code
+		msg  string
+	}{
+		"OK":                    {code: codes.OK},
+		"error":                 {code: codes.NotFound, msg: "missing"},
+		"message to be escaped": {code: codes.Internal, msg: "100% broken\r\nx-injected: true"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: TrailerFrame(c.code, c.msg)}, nil))
+			var trailer metadata.MD
+			_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}), Trailer(&trailer))
+			assert.Equal(t, c.code, status.Code(err))
+			assert.Equal(t, c.msg, status.Convert(err).Message())
+			assert.Empty(t, trailer.Get("x-injected"))
+		})
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	// reserve an address on which no server listens yet.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	req := NewRequest("/api.Example/Unary", &wrappers.StringValue{Value: "foo"}, &wrappers.StringValue{})
+
+	t.Run("without WithWaitForReady", func(t *testing.T) {
+		client := NewClient(addr, WithInsecure())
+		_, err := client.Unary(context.Background(), req)
+		assert.Equal(t, codes.Unknown, status.Code(err), "%v", err)
+	})
+
+	t.Run("the deadline is exceeded", func(t *testing.T) {
+		client := NewClient(addr, WithInsecure(), WithWaitForReady())
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		_, err := client.Unary(ctx, req)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "%v", err)
+	})
+
+	t.Run("the server becomes ready", func(t *testing.T) {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			b, _ := ioutil.ReadAll(r.Body)
+			w.Write(b)
+			w.Write(TrailerFrame(codes.OK, ""))
+		}))
+		defer srv.Close()
+		started := make(chan error, 1)
+		time.AfterFunc(200*time.Millisecond, func() {
+			l, err := net.Listen("tcp", addr)
+			if err == nil {
+				srv.Listener = l
+				srv.Start()
+			}
+			started <- err
+		})
+
+		client := NewClient(addr, WithInsecure(), WithWaitForReady())
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		out := &wrappers.StringValue{}
+		_, err := client.Unary(ctx, NewRequest("/api.Example/Unary", &wrappers.StringValue{Value: "foo"}, out))
+		require.NoError(t, <-started)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", out.Value, "the request body must be sent again")
+	})
+}
+
+func TestTrailersOnlyResponse(t *testing.T) {
+	b, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+	require.NoError(t, err)
+	message := append(header(b), b...)
+
+	cases := map[string]struct {
+		header       metadata.MD
+		res          []byte
+		code         codes.Code
+		trailersOnly bool
+	}{
+		"message and trailer": {res: append(message, TrailerFrame(codes.OK, "")...), code: codes.OK},
+		"trailer frame only":  {res: TrailerFrame(codes.NotFound, "missing"), code: codes.NotFound, trailersOnly: true},
+		"OK in the headers":   {header: metadata.Pairs("grpc-status", "0"), code: codes.OK, trailersOnly: true},
+		"error in the headers": {
+			header:       metadata.Pairs("grpc-status", "5", "grpc-message", "missing"),
+			code:         codes.NotFound,
+			trailersOnly: true,
+		},
+		"empty body without status": {code: codes.Unknown},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Run("unary", func(t *testing.T) {
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{header: c.header, res: c.res}, nil))
+				res, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}))
+				assert.Equal(t, c.code, status.Code(err), "%v", err)
+				if err == nil {
+					assert.Equal(t, c.trailersOnly, res.TrailersOnly)
+				}
+			})
+
+			t.Run("server streaming", func(t *testing.T) {
+				if c.header == nil {
+					t.Skip("the status is not sent in the headers")
+				}
+				client := NewClient(defaultAddr, withStubTransport(&stubTransport{header: c.header, res: c.res}, nil))
+				stream, err := client.ServerStreaming(context.Background(), NewRequest("/api.Example/ServerStreaming", &wrappers.StringValue{}, &wrappers.StringValue{}))
+				require.NoError(t, err)
+				_, err = stream.Receive()
+				if c.code == codes.OK {
+					assert.Equal(t, io.EOF, err)
+				} else {
+					assert.Equal(t, c.code, status.Code(err), "%v", err)
+				}
+				assert.Equal(t, c.header, stream.(ServerStream).Trailer())
+			})
+		})
+	}
+}
+
+var benchmarkMessageSizes = []int{0, 64, 1024, 64 * 1024, 1024 * 1024}
+
+func BenchmarkParseRequestBody(b *testing.B) {
+	codec := encoding.GetCodec("proto")
+	for _, n := range benchmarkMessageSizes {
+		in := &wrappers.BytesValue{Value: make([]byte, n)}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r, err := parseRequestBody(codec, nil, 0, in)
+				if err != nil {
+					b.Fatal(err)
+				}
+				releaseBuffer(r)
+			}
+		})
+	}
+}
+
+func BenchmarkParseResponseBody(b *testing.B) {
+	for _, n := range benchmarkMessageSizes {
+		res := frame(0x00, make([]byte, n))
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			r := bytes.NewReader(res)
+			for i := 0; i < b.N; i++ {
+				r.Reset(res)
+				if _, err := parseResponseBody(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// discardTransport discards request bodies, and responds an empty message.
+type discardTransport struct{}
+
+func (discardTransport) Send(_ context.Context, body io.Reader) (io.ReadCloser, error) {
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(frame(flagTrailer, []byte("grpc-status: 0\r\n")))), nil
+}
+
+func (discardTransport) Header() metadata.MD {
+	return nil
+}
+
+func BenchmarkUnaryLargeMessage(b *testing.B) {
+	client := NewClient(defaultAddr, WithTransportBuilder(func(string, *Request) Transport {
+		return discardTransport{}
+	}))
+	in := &wrappers.BytesValue{Value: make([]byte, 10<<20)}
+	req := NewRequest("/api.Example/Unary", in, &empty.Empty{})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Unary(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// discardStreamTransport discards request messages.
+type discardStreamTransport struct {
+	stubStreamTransport
+}
+
+func (discardStreamTransport) Send(body io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, body)
+	return err
+}
+
+func BenchmarkStreamSend(b *testing.B) {
+	client := NewClient(defaultAddr, WithStreamTransportBuilder(func(string, string) (StreamTransport, error) {
+		return &discardStreamTransport{}, nil
+	}), WithTransportBuilder(func(string, *Request) Transport {
+		return discardTransport{}
+	}))
+	for _, n := range benchmarkMessageSizes {
+		req := NewRequest("/api.Example/BidiStreaming", &wrappers.BytesValue{Value: make([]byte, n)}, &empty.Empty{})
+		b.Run(fmt.Sprintf("bidi streaming/%d", n), func(b *testing.B) {
+			stream, err := client.BidiStreaming(context.Background(), req)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := stream.Send(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("server streaming/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				stream, err := client.ServerStreaming(context.Background(), req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := stream.Receive(); err != io.EOF {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func extractMessage(t *testing.T, res *Response) string {
 	require.NotNil(t, res.Content)
 
@@ -0,0 +1,85 @@
+package grpcweb
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/encoding"
+	pb "google.golang.org/grpc/encoding/proto"
+)
+
+// ProtoMarshalOptions configures how the proto codec marshals messages.
+type ProtoMarshalOptions struct {
+	// Deterministic sorts map entries by key, so that the same message is always marshaled into the same bytes.
+	// It is useful for caching and reproducible request bodies.
+	Deterministic bool
+}
+
+// WithProtoMarshalOptions makes the client use the proto codec which marshals messages with opts.
+// It replaces the codec specified by WithCodec.
+func WithProtoMarshalOptions(opts ProtoMarshalOptions) ClientOption {
+	return func(c *Client) {
+		c.codec = &protoCodec{opts: opts}
+	}
+}
+
+// protoCodec is the proto codec which is configured by ProtoMarshalOptions.
+type protoCodec struct {
+	opts ProtoMarshalOptions
+}
+
+func (c *protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	// messages which marshal themselves, like *dynamic.Message, ignore the options of proto.Buffer.
+	if d, ok := m.(interface {
+		MarshalDeterministic() ([]byte, error)
+	}); ok && c.opts.Deterministic {
+		return d.MarshalDeterministic()
+	}
+
+	b := proto.NewBuffer(nil)
+	b.SetDeterministic(c.opts.Deterministic)
+	if err := b.Marshal(m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (c *protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (c *protoCodec) Name() string {
+	return pb.Name
+}
+
+// WithRequestValidation makes the client call Validate of request messages before sending them,
+// if they have the method like messages generated by protoc-gen-validate.
+// A request which fails the validation is not sent, and errors.Cause of the returned error is the validation error.
+func WithRequestValidation() ClientOption {
+	return func(c *Client) {
+		c.validateRequests = true
+	}
+}
+
+// validatingCodec validates messages before marshaling them by the underlying codec.
+type validatingCodec struct {
+	encoding.Codec
+}
+
+func (c validatingCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(interface {
+		Validate() error
+	}); ok {
+		if err := m.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid request")
+		}
+	}
+	return c.Codec.Marshal(v)
+}
@@ -0,0 +1,93 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/jhump/protoreflect/dynamic"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestProtoCodec(t *testing.T) {
+	in := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	for i := 0; i < 20; i++ {
+		in.Fields[fmt.Sprint(i)] = &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: float64(i)}}
+	}
+	expected := proto.NewBuffer(nil)
+	expected.SetDeterministic(true)
+	require.NoError(t, expected.Marshal(in))
+
+	codec := &protoCodec{opts: ProtoMarshalOptions{Deterministic: true}}
+	assert.Equal(t, "proto", codec.Name())
+	for i := 0; i < 5; i++ {
+		b, err := codec.Marshal(in)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Bytes(), b)
+	}
+
+	var out structpb.Struct
+	require.NoError(t, codec.Unmarshal(expected.Bytes(), &out))
+	assert.True(t, proto.Equal(in, &out))
+
+	t.Run("dynamic message", func(t *testing.T) {
+		m, err := dynamic.AsDynamicMessage(in)
+		require.NoError(t, err)
+		b, err := codec.Marshal(m)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Bytes(), b)
+	})
+
+	_, err := codec.Marshal("foo")
+	assert.Error(t, err)
+}
+
+var errEmptyValue = errors.New("value must not be empty")
+
+// validatedString has the Validate method like messages generated by protoc-gen-validate.
+type validatedString struct {
+	wrappers.StringValue
+}
+
+func (m *validatedString) Validate() error {
+	if m.Value == "" {
+		return errEmptyValue
+	}
+	return nil
+}
+
+func TestWithRequestValidation(t *testing.T) {
+	cases := map[string]struct {
+		opts []ClientOption
+		in   proto.Message
+		err  error
+	}{
+		"valid":               {opts: []ClientOption{WithRequestValidation()}, in: &validatedString{wrappers.StringValue{Value: "foo"}}},
+		"invalid":             {opts: []ClientOption{WithRequestValidation()}, in: &validatedString{}, err: errEmptyValue},
+		"without Validate":    {opts: []ClientOption{WithRequestValidation()}, in: &wrappers.StringValue{}},
+		"validation disabled": {in: &validatedString{}},
+		"with a custom codec": {opts: []ClientOption{WithProtoMarshalOptions(ProtoMarshalOptions{}), WithRequestValidation()}, in: &validatedString{}, err: errEmptyValue},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			tr := &stubTransport{res: TrailerFrame(codes.OK, "")}
+			client := NewClient(defaultAddr, append(c.opts, withStubTransport(tr, nil))...)
+			_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", c.in, &wrappers.StringValue{}))
+			if c.err == nil {
+				require.NoError(t, err)
+				assert.NotNil(t, tr.sent)
+				return
+			}
+			assert.Equal(t, c.err, pkgerrors.Cause(err))
+			assert.Nil(t, tr.sent, "the invalid request must not be sent")
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// ConnectTransport is a Transport implementation which speaks the Connect unary protocol.
+// (https://connectrpc.com/docs/protocol)
+//
+// The Connect unary protocol sends messages without the length-prefixed framing, and reports errors as JSON bodies.
+// ConnectTransport converts them to gRPC Web frames, so that Client can handle them in the same way as gRPC Web responses.
+// Streaming APIs are not supported.
+type ConnectTransport struct {
+	sent bool
+
+	host      string
+	authority string
+	path      string
+	req       *Request
+	client    *http.Client
+
+	insecure    bool
+	contentType string
+	userAgent   string
+	compression string
+
+	header metadata.MD
+}
+
+// ConnectTransportBuilder builds ConnectTransport. Pass it to WithTransportBuilderWithOptions to talk with Connect servers.
+// The content-type is derived from the codec. (e.g. "application/proto" for the proto codec)
+func ConnectTransportBuilder(host string, req *Request, opts *TransportOptions) Transport {
+	client := opts.HTTPClient
+	if client == nil {
+		client = newHTTPClient(opts)
+	}
+	return &ConnectTransport{
+		host:        host,
+		authority:   opts.Authority,
+		path:        opts.path(req.endpoint),
+		req:         req,
+		client:      client,
+		insecure:    opts.Insecure,
+		contentType: connectContentType(opts.contentType()),
+		userAgent:   opts.userAgent(),
+		compression: opts.Compression,
+	}
+}
+
+// connectContentType converts a gRPC Web content-type to a Connect unary one.
+// (e.g. "application/grpc-web+json" to "application/json")
+func connectContentType(ct string) string {
+	codec := "proto"
+	if i := strings.LastIndex(ct, "+"); i != -1 {
+		codec = ct[i+1:]
+	}
+	return "application/" + codec
+}
+
+func (t *ConnectTransport) Send(ctx context.Context, body io.Reader) (io.ReadCloser, error) {
+	if t.sent {
+		return nil, ErrTransportReused
+	}
+	defer func() {
+		t.sent = true
+	}()
+
+	// the Connect unary protocol doesn't use the length-prefixed framing.
+	flag, msg, err := readFrame(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the request body")
+	}
+
+	protocol := "https"
+	if t.insecure {
+		protocol = "http"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s://%s%s", protocol, t.host, t.path), bytes.NewReader(msg))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build the API request")
+	}
+	if t.authority != "" {
+		req.Host = t.authority
+	}
+
+	req.Header.Set("content-type", t.contentType)
+	req.Header.Set("connect-protocol-version", "1")
+	req.Header.Set("user-agent", t.userAgent)
+	if flag&flagCompressed != 0 {
+		req.Header.Set("content-encoding", t.compression)
+	}
+	for k, vs := range HeaderFromContext(ctx) {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, errors.Wrap(context.DeadlineExceeded, "the deadline has been exceeded before sending the request")
+		}
+		req.Header.Set("connect-timeout-ms", strconv.FormatInt(divCeil(timeout, time.Millisecond), 10))
+	}
+
+	res, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send the API")
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the response body")
+	}
+
+	// trailers are sent as headers which have "trailer-" prefix.
+	resHeader, trailer := metadata.MD{}, metadata.MD{}
+	for k, vs := range res.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "trailer-") {
+			trailer.Append(strings.TrimPrefix(lk, "trailer-"), vs...)
+		} else {
+			resHeader.Append(k, vs...)
+		}
+	}
+	t.header = resHeader
+
+	var buf bytes.Buffer
+	if res.StatusCode == http.StatusOK {
+		buf.Write(header(b))
+		buf.Write(b)
+		trailer.Set("grpc-status", "0")
+	} else {
+		for k, vs := range statusTrailer(parseConnectError(res.StatusCode, b)) {
+			trailer.Set(k, vs...)
+		}
+	}
+
+	buf.Write(trailerFrame(trailer))
+
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (t *ConnectTransport) Header() metadata.MD {
+	return t.header
+}
+
+var connectCodes = map[string]codes.Code{
+	"canceled":            codes.Canceled,
+	"unknown":             codes.Unknown,
+	"invalid_argument":    codes.InvalidArgument,
+	"deadline_exceeded":   codes.DeadlineExceeded,
+	"not_found":           codes.NotFound,
+	"already_exists":      codes.AlreadyExists,
+	"permission_denied":   codes.PermissionDenied,
+	"resource_exhausted":  codes.ResourceExhausted,
+	"failed_precondition": codes.FailedPrecondition,
+	"aborted":             codes.Aborted,
+	"out_of_range":        codes.OutOfRange,
+	"unimplemented":       codes.Unimplemented,
+	"internal":            codes.Internal,
+	"unavailable":         codes.Unavailable,
+	"data_loss":           codes.DataLoss,
+	"unauthenticated":     codes.Unauthenticated,
+}
+
+// parseConnectError parses the JSON error body of the Connect unary protocol.
+// If the body is not a Connect error, the code is inferred from the HTTP status code.
+func parseConnectError(statusCode int, b []byte) (codes.Code, string) {
+	var e struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &e); err == nil {
+		if code, ok := connectCodes[e.Code]; ok {
+			return code, e.Message
+		}
+	}
+
+	msg := fmt.Sprintf("unexpected HTTP status %d %s", statusCode, http.StatusText(statusCode))
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.Internal, msg
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated, msg
+	case http.StatusForbidden:
+		return codes.PermissionDenied, msg
+	case http.StatusNotFound:
+		return codes.Unimplemented, msg
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable, msg
+	}
+	return codes.Unknown, msg
+}
@@ -0,0 +1,85 @@
+package grpcweb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnectTransport(t *testing.T) {
+	pkg := getAPIProto(t)
+	service := pkg.getServiceByName(t, "Example")
+	endpoint := ToEndpoint("api", service, service.GetMethod()[0])
+	message := readFile(t, "unary_ktr.out")[headerLen : headerLen+12]
+
+	cases := map[string]struct {
+		handler func(w http.ResponseWriter)
+		code    codes.Code
+		message string
+	}{
+		"OK": {
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("content-type", "application/proto")
+				w.Write(message)
+			},
+			code: codes.OK,
+		},
+		"error": {
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"code":"not_found","message":"no such user"}`))
+			},
+			code:    codes.NotFound,
+			message: "no such user",
+		},
+		"non-Connect error": {
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("content-type", "text/html")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("<html>unavailable</html>"))
+			},
+			code:    codes.Unavailable,
+			message: "unexpected HTTP status 503 Service Unavailable",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			var header http.Header
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				body, _ = ioutil.ReadAll(r.Body)
+				c.handler(w)
+			}))
+			defer srv.Close()
+
+			client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithTransportBuilderWithOptions(ConnectTransportBuilder))
+			in, out := pkg.getMessageTypeByName(t, "SimpleRequest"), pkg.getMessageTypeByName(t, "SimpleResponse")
+			in.SetFieldByName("name", "ktr")
+			res, err := client.Unary(context.Background(), NewRequest(endpoint, in, out))
+			assert.Equal(t, c.code, status.Code(err), "%v", err)
+			if c.code == codes.OK {
+				require.NoError(t, err)
+				assert.Equal(t, "hello, ktr", extractMessage(t, res))
+			} else {
+				assert.Equal(t, c.message, status.Convert(err).Message())
+			}
+
+			assert.Equal(t, "application/proto", header.Get("content-type"))
+			assert.Equal(t, "1", header.Get("connect-protocol-version"))
+			expected, err := in.Marshal()
+			require.NoError(t, err)
+			assert.Equal(t, expected, body, "the request body must not be framed")
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package grpcweb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithMetadataCredentialsFromFile sends the bearer token stored in the file at path as authorization header of each request.
+// The file is read again when its modification time or size changes, so that rotated tokens are picked up.
+// Surrounding whitespace of the token is trimmed. It is a shorthand of WithPerRPCCredentials.
+func WithMetadataCredentialsFromFile(path string) ClientOption {
+	return WithPerRPCCredentials(&fileCredentials{path: path})
+}
+
+// fileCredentials caches the token in the file until the file is changed.
+type fileCredentials struct {
+	path string
+
+	m       sync.Mutex
+	modTime time.Time
+	size    int64
+	token   string
+}
+
+func (c *fileCredentials) GetRequestMetadata(context.Context) (map[string]string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat the token file")
+	}
+	if c.token == "" || !fi.ModTime().Equal(c.modTime) || fi.Size() != c.size {
+		b, err := ioutil.ReadFile(c.path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the token file")
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return nil, errors.Errorf("the token file %s is empty", c.path)
+		}
+		c.token, c.modTime, c.size = token, fi.ModTime(), fi.Size()
+	}
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
@@ -0,0 +1,55 @@
+package grpcweb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestWithMetadataCredentialsFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpcweb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("authorization")
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write(TrailerFrame(codes.OK, ""))
+	}))
+	defer srv.Close()
+
+	client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithMetadataCredentialsFromFile(path))
+	call := func() error {
+		_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}))
+		return err
+	}
+
+	assert.Error(t, call(), "the call must fail if the file doesn't exist")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("token1\n"), 0600))
+	require.NoError(t, call())
+	assert.Equal(t, "Bearer token1", auth)
+
+	// the rotated token has the same size, so it is detected by the modification time.
+	require.NoError(t, ioutil.WriteFile(path, []byte("token2\n"), 0600))
+	mtime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+	require.NoError(t, call())
+	assert.Equal(t, "Bearer token2", auth)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("  \n"), 0600))
+	assert.Error(t, call(), "the call must fail if the file is empty")
+}
@@ -0,0 +1,72 @@
+package grpcweb
+
+import (
+	"github.com/jhump/protoreflect/desc"
+	"github.com/pkg/errors"
+)
+
+// ServiceDescription describes a service and its methods.
+type ServiceDescription struct {
+	// Name is the fully-qualified name of the service. (e.g. "api.Example")
+	Name    string
+	Methods []*MethodDescription
+}
+
+// MethodDescription describes a method of a service.
+type MethodDescription struct {
+	Name string
+
+	// Endpoint is the endpoint passed to NewRequest. (e.g. "/api.Example/Unary")
+	Endpoint string
+
+	ClientStreaming bool
+	ServerStreaming bool
+
+	Input  *desc.MessageDescriptor
+	Output *desc.MessageDescriptor
+}
+
+// Kind returns the API kind of the method. (e.g. "unary" or "server streaming")
+func (m *MethodDescription) Kind() string {
+	return kindName(m.ClientStreaming, m.ServerStreaming)
+}
+
+// ListServices returns fully-qualified names of services declared in fd.
+func ListServices(fd *desc.FileDescriptor) []string {
+	services := fd.GetServices()
+	names := make([]string, 0, len(services))
+	for _, s := range services {
+		names = append(names, s.GetFullyQualifiedName())
+	}
+	return names
+}
+
+// DescribeService describes the service named name in fd.
+// name may be either of the fully-qualified name (e.g. "api.Example") or the simple name (e.g. "Example").
+func DescribeService(fd *desc.FileDescriptor, name string) (*ServiceDescription, error) {
+	sd := fd.FindService(name)
+	if sd == nil {
+		for _, s := range fd.GetServices() {
+			if s.GetName() == name {
+				sd = s
+				break
+			}
+		}
+	}
+	if sd == nil {
+		return nil, errors.Errorf("no such service in %s: %s", fd.GetName(), name)
+	}
+
+	d := &ServiceDescription{Name: sd.GetFullyQualifiedName()}
+	for _, m := range sd.GetMethods() {
+		d.Methods = append(d.Methods, &MethodDescription{
+			Name:            m.GetName(),
+			Endpoint:        ToEndpoint(fd.GetPackage(), sd.AsServiceDescriptorProto(), m.AsMethodDescriptorProto()),
+			ClientStreaming: m.IsClientStreaming(),
+			ServerStreaming: m.IsServerStreaming(),
+			Input:           m.GetInputType(),
+			Output:          m.GetOutputType(),
+		})
+	}
+	return d, nil
+}
@@ -0,0 +1,45 @@
+package grpcweb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListServices(t *testing.T) {
+	pkg := getAPIProto(t)
+	assert.Equal(t, []string{"api.Example"}, ListServices(pkg.FileDescriptor))
+}
+
+func TestDescribeService(t *testing.T) {
+	pkg := getAPIProto(t)
+
+	for _, name := range []string{"api.Example", "Example"} {
+		d, err := DescribeService(pkg.FileDescriptor, name)
+		require.NoError(t, err, name)
+		assert.Equal(t, "api.Example", d.Name)
+
+		methods := map[string]*MethodDescription{}
+		for _, m := range d.Methods {
+			methods[m.Name] = m
+		}
+		cases := map[string]string{
+			"Unary":           "unary",
+			"ServerStreaming": "server streaming",
+			"ClientStreaming": "client streaming",
+			"BidiStreaming":   "bidirectional streaming",
+		}
+		for method, kind := range cases {
+			m, ok := methods[method]
+			require.True(t, ok, method)
+			assert.Equal(t, "/api.Example/"+method, m.Endpoint)
+			assert.Equal(t, kind, m.Kind())
+			assert.Equal(t, "api.SimpleRequest", m.Input.GetFullyQualifiedName())
+			assert.Equal(t, "api.SimpleResponse", m.Output.GetFullyQualifiedName())
+		}
+	}
+
+	_, err := DescribeService(pkg.FileDescriptor, "Foo")
+	assert.Error(t, err)
+}
@@ -0,0 +1,42 @@
+// Package snappy implements the snappy compressor, and registers it to the compressor registry of gRPC.
+// It is a separate package so that only users who need snappy depend on its implementation.
+// Import it for the side effect, then pass the compressor to grpcweb.WithCompressor:
+//
+//   import (
+//   	"google.golang.org/grpc/encoding"
+//   	_ "github.com/ktr0731/grpc-web-go-client/grpcweb/encoding/snappy"
+//   )
+//
+//   client := grpcweb.NewClient("localhost:50051", grpcweb.WithCompressor(encoding.GetCompressor("snappy")))
+//
+// Messages are encoded in the snappy framing format, like other gRPC implementations of snappy.
+// Responses compressed by snappy are also decompressed by it once the package is imported.
+package snappy
+
+import (
+	"io"
+
+	"github.com/golang/snappy"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the name of the compressor, which is sent as grpc-encoding header.
+const Name = "snappy"
+
+func init() {
+	encoding.RegisterCompressor(compressor{})
+}
+
+type compressor struct{}
+
+func (compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (compressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
+
+func (compressor) Name() string {
+	return Name
+}
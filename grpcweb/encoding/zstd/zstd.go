@@ -0,0 +1,59 @@
+// Package zstd implements the zstd compressor, and registers it to the compressor registry of gRPC.
+// It is a separate package so that only users who need zstd depend on its implementation.
+// Import it for the side effect, then pass the compressor to grpcweb.WithCompressor:
+//
+//   import (
+//   	"google.golang.org/grpc/encoding"
+//   	_ "github.com/ktr0731/grpc-web-go-client/grpcweb/encoding/zstd"
+//   )
+//
+//   client := grpcweb.NewClient("localhost:50051", grpcweb.WithCompressor(encoding.GetCompressor("zstd")))
+//
+// Responses compressed by zstd are also decompressed by it once the package is imported.
+package zstd
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the name of the compressor, which is sent as grpc-encoding header.
+const Name = "zstd"
+
+func init() {
+	encoding.RegisterCompressor(compressor{})
+}
+
+type compressor struct{}
+
+func (compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	// each message is compressed separately, so encoding blocks concurrently doesn't pay.
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (compressor) Decompress(r io.Reader) (io.Reader, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{d: d}, nil
+}
+
+func (compressor) Name() string {
+	return Name
+}
+
+// decoder releases the resources of the zstd decoder when it reaches the end or fails.
+type decoder struct {
+	d *zstd.Decoder
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	n, err := d.d.Read(p)
+	if err != nil {
+		d.d.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,66 @@
+package zstd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestCompressor(t *testing.T) {
+	comp := encoding.GetCompressor(Name)
+	require.NotNil(t, comp, "the compressor must be registered")
+
+	in := bytes.Repeat([]byte("grpc-web "), 1024)
+	var buf bytes.Buffer
+	w, err := comp.Compress(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(in)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.True(t, buf.Len() < len(in), "the message must be compressed")
+
+	r, err := comp.Decompress(&buf)
+	require.NoError(t, err)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestClient(t *testing.T) {
+	comp := encoding.GetCompressor(Name)
+
+	// the server echoes the request message compressed by the same compressor.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, Name, r.Header.Get("grpc-encoding"))
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.True(t, len(b) > 5)
+		assert.Equal(t, byte(0x01), b[0], "the request message must be compressed")
+
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Header().Set("grpc-encoding", Name)
+		w.Write(b)
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	client := grpcweb.NewClient(strings.TrimPrefix(srv.URL, "http://"), grpcweb.WithInsecure(), grpcweb.WithCompressor(comp))
+	defer client.Close()
+
+	in, out := &wrappers.StringValue{Value: strings.Repeat("ktr", 100)}, &wrappers.StringValue{}
+	_, err := client.Unary(context.Background(), grpcweb.NewRequest("/api.Example/Unary", in, out))
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(in, out))
+}
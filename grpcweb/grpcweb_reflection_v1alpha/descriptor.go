@@ -0,0 +1,38 @@
+package grpcweb_reflection_v1alpha
+
+import (
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb"
+	"github.com/pkg/errors"
+	context "golang.org/x/net/context"
+)
+
+// FileDescriptors lists services of the server by the server reflection,
+// and returns file descriptors which declare them.
+// The reflection is requested through the stream transport of cc. (WebSocket by default)
+func FileDescriptors(ctx context.Context, cc *grpcweb.Client) ([]*desc.FileDescriptor, error) {
+	client := grpcreflect.NewClient(ctx, NewServerReflectionClient(cc))
+	defer client.Reset()
+
+	services, err := client.ListServices()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list services")
+	}
+
+	var fds []*desc.FileDescriptor
+	seen := map[string]bool{}
+	for _, s := range services {
+		sd, err := client.ResolveService(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve service %s", s)
+		}
+		fd := sd.GetFile()
+		if seen[fd.GetName()] {
+			continue
+		}
+		seen[fd.GetName()] = true
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
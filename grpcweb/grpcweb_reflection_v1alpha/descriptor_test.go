@@ -0,0 +1,128 @@
+package grpcweb_reflection_v1alpha
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// reflectionFileDescriptor returns the file descriptor of grpc_reflection_v1alpha/reflection.proto.
+func reflectionFileDescriptor() (*descpb.FileDescriptorProto, error) {
+	gz, _ := (&pb.ServerReflectionRequest{}).Descriptor()
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var fd descpb.FileDescriptorProto
+	if err := proto.Unmarshal(b, &fd); err != nil {
+		return nil, err
+	}
+	return &fd, nil
+}
+
+// reflectionTransport is a stub stream transport which serves the server reflection of the ServerReflection service itself.
+type reflectionTransport struct {
+	responses [][]byte
+}
+
+func (t *reflectionTransport) Send(body io.Reader) error {
+	_, b, err := grpcweb.NewFrameReader(body).Next()
+	if err != nil {
+		return err
+	}
+	var req pb.ServerReflectionRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	fd, err := reflectionFileDescriptor()
+	if err != nil {
+		return err
+	}
+	res := &pb.ServerReflectionResponse{OriginalRequest: &req}
+	switch r := req.MessageRequest.(type) {
+	case *pb.ServerReflectionRequest_ListServices:
+		res.MessageResponse = &pb.ServerReflectionResponse_ListServicesResponse{
+			ListServicesResponse: &pb.ListServiceResponse{
+				Service: []*pb.ServiceResponse{{Name: "grpc.reflection.v1alpha.ServerReflection"}},
+			},
+		}
+	case *pb.ServerReflectionRequest_FileContainingSymbol:
+		if r.FileContainingSymbol != "grpc.reflection.v1alpha.ServerReflection" {
+			res.MessageResponse = &pb.ServerReflectionResponse_ErrorResponse{
+				ErrorResponse: &pb.ErrorResponse{ErrorCode: int32(codes.NotFound), ErrorMessage: "not found"},
+			}
+			break
+		}
+		b, err := proto.Marshal(fd)
+		if err != nil {
+			return err
+		}
+		res.MessageResponse = &pb.ServerReflectionResponse_FileDescriptorResponse{
+			FileDescriptorResponse: &pb.FileDescriptorResponse{FileDescriptorProto: [][]byte{b}},
+		}
+	default:
+		res.MessageResponse = &pb.ServerReflectionResponse_ErrorResponse{
+			ErrorResponse: &pb.ErrorResponse{ErrorCode: int32(codes.Unimplemented), ErrorMessage: "unimplemented"},
+		}
+	}
+
+	b, err = proto.Marshal(res)
+	if err != nil {
+		return err
+	}
+	f := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(f[1:], uint32(len(b)))
+	t.responses = append(t.responses, append(f, b...))
+	return nil
+}
+
+func (t *reflectionTransport) Receive() (io.ReadCloser, error) {
+	if len(t.responses) == 0 {
+		return nil, io.EOF
+	}
+	res := t.responses[0]
+	t.responses = t.responses[1:]
+	return ioutil.NopCloser(bytes.NewReader(res)), nil
+}
+
+func (t *reflectionTransport) Finish() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(grpcweb.TrailerFrame(codes.OK, ""))), nil
+}
+
+func (t *reflectionTransport) Close() error {
+	return nil
+}
+
+func TestFileDescriptors(t *testing.T) {
+	client := grpcweb.NewClient("localhost:50051", grpcweb.WithStreamTransportBuilder(func(string, string) (grpcweb.StreamTransport, error) {
+		return &reflectionTransport{}, nil
+	}))
+
+	fds, err := FileDescriptors(context.Background(), client)
+	require.NoError(t, err)
+	require.Len(t, fds, 1)
+
+	fd, err := reflectionFileDescriptor()
+	require.NoError(t, err)
+	assert.Equal(t, fd.GetName(), fds[0].GetName())
+	sd := fds[0].FindService("grpc.reflection.v1alpha.ServerReflection")
+	require.NotNil(t, sd)
+	assert.NotNil(t, sd.FindMethodByName("ServerReflectionInfo"))
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func parseProto(t *testing.T, fname string) []*desc.FileDescriptor {
+func parseProto(t testing.TB, fname string) []*desc.FileDescriptor {
 	t.Helper()
 
 	p := &protoparse.Parser{
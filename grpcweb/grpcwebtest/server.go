@@ -0,0 +1,101 @@
+package grpcwebtest
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryHandler handles a request message of an unary API, and returns the response message.
+// Messages are encoded by the codec which is specified by the content-type. (e.g. Protocol Buffers)
+// The returned error is converted to the status by status.Convert.
+type UnaryHandler func(ctx context.Context, in []byte) ([]byte, error)
+
+// ServeMux is a gRPC Web server handler which dispatches requests to registered handlers by the endpoint.
+// It is minimal implementation for testing, so it supports only unary APIs.
+// Request headers are available as incoming metadata of the context.
+type ServeMux struct {
+	m        sync.RWMutex
+	handlers map[string]UnaryHandler
+}
+
+// NewServeMux instantiates a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{
+		handlers: map[string]UnaryHandler{},
+	}
+}
+
+// HandleUnary registers the handler for endpoint. (e.g. "/api.Example/Unary")
+func (m *ServeMux) HandleUnary(endpoint string, h UnaryHandler) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.handlers[endpoint] = h
+}
+
+func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ct := r.Header.Get("content-type")
+	if !strings.HasPrefix(ct, "application/grpc-web") {
+		http.Error(w, "unsupported content-type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("content-type", ct)
+
+	m.m.RLock()
+	h, ok := m.handlers[r.URL.Path]
+	m.m.RUnlock()
+	if !ok {
+		w.Write(trailerFrame(status.Errorf(codes.Unimplemented, "unknown method %s", r.URL.Path)))
+		return
+	}
+
+	in, err := readMessage(r.Body)
+	if err != nil {
+		w.Write(trailerFrame(status.Errorf(codes.Internal, "failed to read the request: %s", err)))
+		return
+	}
+
+	md := metadata.MD{}
+	for k, vs := range r.Header {
+		md.Append(k, vs...)
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+
+	out, err := h(ctx, in)
+	if err == nil {
+		w.Write(frame(0x00, out))
+	}
+	w.Write(trailerFrame(err))
+}
+
+// readMessage reads a message frame from r.
+func readMessage(r io.Reader) ([]byte, error) {
+	var h [5]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	if h[0] != 0x00 {
+		return nil, status.Errorf(codes.Unimplemented, "unsupported flag: %#x", h[0])
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(binary.BigEndian.Uint32(h[1:]))))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != int(binary.BigEndian.Uint32(h[1:])) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
@@ -0,0 +1,62 @@
+package grpcwebtest
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleUnary("/api.Example/Unary", func(ctx context.Context, b []byte) ([]byte, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get("x-grpc-web")) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "x-grpc-web is missing")
+		}
+
+		var in wrappers.StringValue
+		if err := proto.Unmarshal(b, &in); err != nil {
+			return nil, err
+		}
+		if in.Value == "" {
+			return nil, status.Error(codes.InvalidArgument, "empty name")
+		}
+		return proto.Marshal(&wrappers.StringValue{Value: "hello, " + in.Value})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := grpcweb.NewClient(strings.TrimPrefix(srv.URL, "http://"), grpcweb.WithInsecure())
+
+	cases := map[string]struct {
+		endpoint, name string
+		code           codes.Code
+		expected       string
+	}{
+		"OK":             {endpoint: "/api.Example/Unary", name: "ktr", expected: "hello, ktr"},
+		"non-OK":         {endpoint: "/api.Example/Unary", code: codes.InvalidArgument},
+		"unknown method": {endpoint: "/api.Example/Unknown", name: "ktr", code: codes.Unimplemented},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			out := &wrappers.StringValue{}
+			_, err := client.Unary(context.Background(), grpcweb.NewRequest(c.endpoint, &wrappers.StringValue{Value: c.name}, out))
+			assert.Equal(t, c.code, status.Code(err), "%v", err)
+			if c.code == codes.OK {
+				require.NoError(t, err)
+				assert.Equal(t, c.expected, out.Value)
+			}
+		})
+	}
+}
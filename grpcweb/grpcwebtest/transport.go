@@ -0,0 +1,143 @@
+// Package grpcwebtest provides utilities for testing applications which use the grpcweb package.
+package grpcwebtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Request is a request which is sent through Transport.
+type Request struct {
+	Endpoint string
+
+	// Body is the framed request body.
+	Body []byte
+
+	// Header is the request headers attached to the call. (e.g. by PerRPCCredentials)
+	Header metadata.MD
+}
+
+// Transport is an in-memory transport which returns canned responses registered per endpoint.
+// Pass Builder to grpcweb.WithTransportBuilder to use it:
+//
+//   tr := grpcwebtest.NewTransport()
+//   tr.Respond("/api.Example/Unary", &api.SimpleResponse{Message: "hello"})
+//   client := grpcweb.NewClient("localhost:50051", grpcweb.WithTransportBuilder(tr.Builder()))
+//
+type Transport struct {
+	m         sync.Mutex
+	responses map[string][][]byte
+	requests  []*Request
+}
+
+// NewTransport instantiates a new in-memory transport.
+func NewTransport() *Transport {
+	return &Transport{
+		responses: map[string][][]byte{},
+	}
+}
+
+// Builder returns a grpcweb.TransportBuilder which builds transports backed by t.
+func (t *Transport) Builder() grpcweb.TransportBuilder {
+	return func(_ string, req *grpcweb.Request) grpcweb.Transport {
+		return &transport{parent: t, endpoint: req.Endpoint()}
+	}
+}
+
+// Enqueue registers a raw response body for endpoint.
+// Responses are returned in the order of registration, and each response is returned only once.
+func (t *Transport) Enqueue(endpoint string, body []byte) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.responses[endpoint] = append(t.responses[endpoint], body)
+}
+
+// Respond registers a response which consists of msgs and the trailer with OK status.
+// For server streaming APIs, each message in msgs is a response of the stream.
+func (t *Transport) Respond(endpoint string, msgs ...proto.Message) error {
+	var b bytes.Buffer
+	for _, msg := range msgs {
+		m, err := proto.Marshal(msg)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the response")
+		}
+		b.Write(frame(0x00, m))
+	}
+	b.Write(trailerFrame(nil))
+	t.Enqueue(endpoint, b.Bytes())
+	return nil
+}
+
+// RespondError registers a trailers-only response which has the status of err.
+func (t *Transport) RespondError(endpoint string, err error) {
+	t.Enqueue(endpoint, trailerFrame(err))
+}
+
+// Requests returns all requests sent through t in the order of sending.
+func (t *Transport) Requests() []*Request {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return append([]*Request(nil), t.requests...)
+}
+
+func (t *Transport) dequeue(req *Request) ([]byte, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.requests = append(t.requests, req)
+
+	queue := t.responses[req.Endpoint]
+	if len(queue) == 0 {
+		return nil, errors.Errorf("no responses are registered for %s", req.Endpoint)
+	}
+	t.responses[req.Endpoint] = queue[1:]
+	return queue[0], nil
+}
+
+type transport struct {
+	parent   *Transport
+	endpoint string
+}
+
+func (t *transport) Send(ctx context.Context, body io.Reader) (io.ReadCloser, error) {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the request body")
+	}
+
+	res, err := t.parent.dequeue(&Request{
+		Endpoint: t.endpoint,
+		Body:     b,
+		Header:   grpcweb.HeaderFromContext(ctx).Copy(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(res)), nil
+}
+
+func (t *transport) Header() metadata.MD {
+	return metadata.Pairs("content-type", "application/grpc-web+proto")
+}
+
+// trailerFrame builds a trailer frame which has the status of err.
+func trailerFrame(err error) []byte {
+	s := status.Convert(err)
+	return grpcweb.TrailerFrame(s.Code(), s.Message())
+}
+
+func frame(flag byte, body []byte) []byte {
+	b := make([]byte, 5, 5+len(body))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:], uint32(len(body)))
+	return append(b, body...)
+}
@@ -0,0 +1,70 @@
+package grpcwebtest
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTransport(t *testing.T) {
+	const endpoint = "/api.Example/Unary"
+
+	tr := NewTransport()
+	require.NoError(t, tr.Respond(endpoint, &wrappers.StringValue{Value: "hello"}))
+	tr.RespondError(endpoint, status.Error(codes.NotFound, "not found"))
+
+	client := grpcweb.NewClient("localhost:50051", grpcweb.WithTransportBuilder(tr.Builder()))
+
+	in := &wrappers.StringValue{Value: "ktr"}
+	out := &wrappers.StringValue{}
+	_, err := client.Unary(context.Background(), grpcweb.NewRequest(endpoint, in, out))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", out.Value)
+
+	_, err = client.Unary(context.Background(), grpcweb.NewRequest(endpoint, in, out))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "not found", status.Convert(err).Message())
+
+	_, err = client.Unary(context.Background(), grpcweb.NewRequest(endpoint, in, out))
+	assert.Error(t, err, "all responses are consumed")
+
+	reqs := tr.Requests()
+	require.Len(t, reqs, 3)
+	b, err := proto.Marshal(in)
+	require.NoError(t, err)
+	for _, req := range reqs {
+		assert.Equal(t, endpoint, req.Endpoint)
+		assert.Equal(t, append([]byte{0, 0, 0, 0, byte(len(b))}, b...), req.Body)
+	}
+}
+
+func TestTransportServerStreaming(t *testing.T) {
+	const endpoint = "/api.Example/ServerStreaming"
+
+	tr := NewTransport()
+	require.NoError(t, tr.Respond(endpoint, &wrappers.StringValue{Value: "foo"}, &wrappers.StringValue{Value: "bar"}))
+
+	client := grpcweb.NewClient("localhost:50051", grpcweb.WithTransportBuilder(tr.Builder()))
+	out := &wrappers.StringValue{}
+	s, err := client.ServerStreaming(context.Background(), grpcweb.NewRequest(endpoint, &wrappers.StringValue{}, out))
+	require.NoError(t, err)
+
+	var values []string
+	for {
+		_, err := s.Receive()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		values = append(values, out.Value)
+	}
+	assert.Equal(t, []string{"foo", "bar"}, values)
+}
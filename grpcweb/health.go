@@ -0,0 +1,23 @@
+package grpcweb
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthCheck calls the standard health checking API (grpc.health.v1.Health/Check), and returns the serving status of service.
+// Empty service means the overall health of the server.
+// If the API returns a non-OK status, the returned error has the same code with a descriptive message.
+func (c *Client) HealthCheck(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	in, out := &healthpb.HealthCheckRequest{Service: service}, &healthpb.HealthCheckResponse{}
+	if _, err := c.Unary(ctx, NewRequest("/grpc.health.v1.Health/Check", in, out)); err != nil {
+		if st, ok := status.FromError(err); ok {
+			return healthpb.HealthCheckResponse_UNKNOWN, status.Errorf(st.Code(), "health check of service %q failed: %s", service, st.Message())
+		}
+		return healthpb.HealthCheckResponse_UNKNOWN, errors.Wrapf(err, "health check of service %q failed", service)
+	}
+	return out.GetStatus(), nil
+}
@@ -0,0 +1,45 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthCheck(t *testing.T) {
+	t.Run("serving status", func(t *testing.T) {
+		b, err := proto.Marshal(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING})
+		require.NoError(t, err)
+		st := &stubTransport{res: append(frame(0x00, b), frame(0x80, []byte("grpc-status: 0\r\n"))...)}
+		client := NewClient(defaultAddr, withStubTransport(st, nil))
+
+		s, err := client.HealthCheck(context.Background(), "api.Example")
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, s)
+		assert.Equal(t, "/grpc.health.v1.Health/Check", st.req.Endpoint())
+
+		var in healthpb.HealthCheckRequest
+		_, body, err := readFrame(bytes.NewReader(st.sent))
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(body, &in))
+		assert.Equal(t, "api.Example", in.GetService())
+	})
+
+	t.Run("non-OK status", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{
+			res: frame(0x80, []byte("grpc-status: 5\r\ngrpc-message: unknown service\r\n")),
+		}, nil))
+
+		s, err := client.HealthCheck(context.Background(), "foo")
+		assert.Equal(t, healthpb.HealthCheckResponse_UNKNOWN, s)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, `health check of service "foo" failed: unknown service`, status.Convert(err).Message())
+	})
+}
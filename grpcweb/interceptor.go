@@ -0,0 +1,32 @@
+package grpcweb
+
+import "context"
+
+// UnaryInvoker sends an unary request. It is the rest of the interceptor chain which ends with sending the request.
+type UnaryInvoker func(ctx context.Context, req *Request, opts ...CallOption) (*Response, error)
+
+// UnaryInterceptor intercepts unary calls of the client, e.g. for authentication, logging or metrics.
+// An interceptor must call invoker to continue the call, and may modify ctx, req and opts before that.
+type UnaryInterceptor func(ctx context.Context, req *Request, invoker UnaryInvoker, opts ...CallOption) (*Response, error)
+
+// WithChainUnaryInterceptor registers interceptors which intercept every unary call of the client,
+// like ChainUnaryInterceptor of grpc-go.
+//
+// The interceptors are called in order: the first one is the outermost, and the last one is the innermost,
+// which calls the invoker sending the request. Interceptors of multiple WithChainUnaryInterceptor are appended in order.
+func WithChainUnaryInterceptor(interceptors ...UnaryInterceptor) ClientOption {
+	return func(c *Client) {
+		c.unaryInterceptors = append(c.unaryInterceptors, interceptors...)
+	}
+}
+
+// chainUnaryInterceptors returns an invoker which calls interceptors in order, and invoker at the end.
+func chainUnaryInterceptors(interceptors []UnaryInterceptor, invoker UnaryInvoker) UnaryInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, req *Request, opts ...CallOption) (*Response, error) {
+			return interceptor(ctx, req, next, opts...)
+		}
+	}
+	return invoker
+}
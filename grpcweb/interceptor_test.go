@@ -0,0 +1,61 @@
+package grpcweb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithChainUnaryInterceptor(t *testing.T) {
+	var calls []string
+	record := func(name string) UnaryInterceptor {
+		return func(ctx context.Context, req *Request, invoker UnaryInvoker, opts ...CallOption) (*Response, error) {
+			calls = append(calls, name+" before")
+			res, err := invoker(ctx, req, opts...)
+			calls = append(calls, name+" after")
+			return res, err
+		}
+	}
+
+	var trailer metadata.MD
+	withTrailer := func(ctx context.Context, req *Request, invoker UnaryInvoker, opts ...CallOption) (*Response, error) {
+		return invoker(ctx, req, append(opts, Trailer(&trailer))...)
+	}
+
+	tr := &stubTransport{res: TrailerFrame(codes.OK, "")}
+	client := NewClient(defaultAddr,
+		withStubTransport(tr, nil),
+		WithChainUnaryInterceptor(record("first"), record("second")),
+		WithChainUnaryInterceptor(withTrailer, record("third")),
+	)
+	_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"first before",
+		"second before",
+		"third before",
+		"third after",
+		"second after",
+		"first after",
+	}, calls)
+	assert.Equal(t, "0", trailer.Get("grpc-status")[0], "CallOptions added by interceptors must be applied")
+
+	t.Run("an interceptor can short-circuit the call", func(t *testing.T) {
+		tr := &stubTransport{res: TrailerFrame(codes.OK, "")}
+		client := NewClient(defaultAddr, withStubTransport(tr, nil), WithChainUnaryInterceptor(
+			func(ctx context.Context, req *Request, invoker UnaryInvoker, opts ...CallOption) (*Response, error) {
+				return nil, status.Error(codes.PermissionDenied, "denied")
+			},
+		))
+		_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}))
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Nil(t, tr.sent)
+	})
+}
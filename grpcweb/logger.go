@@ -0,0 +1,28 @@
+package grpcweb
+
+// Logger receives logs of the client, such as sent requests, received frames and reconnections.
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger discards all logs.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// withLogger returns a frameHook which logs every frame, and then calls f.
+func (f frameHook) withLogger(l Logger) frameHook {
+	return func(dir Direction, flag byte, body []byte) {
+		if flag&flagTrailer != 0 {
+			l.Debugf("grpcweb: %s trailer frame: %v", dir, map[string][]string(parseTrailer(body)))
+		} else {
+			l.Debugf("grpcweb: %s message frame (flag: 0x%02x, length: %d)", dir, flag, len(body))
+		}
+		if f != nil {
+			f(dir, flag, body)
+		}
+	}
+}
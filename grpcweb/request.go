@@ -2,14 +2,21 @@ package grpcweb
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/pkg/errors"
 )
 
 type Request struct {
 	endpoint string
 	in, out  interface{}
+
+	// method is used to determine the API kind.
+	// It is nil if the request is instantiated by NewRequest.
+	method *descriptor.MethodDescriptorProto
 }
 
 // NewRequest instantiates new API request from passed endpoint and I/O types.
@@ -17,11 +24,20 @@ type Request struct {
 //
 //   "/{package name}.{service name}/{method name}"
 //
+// The package name is omitted for services without a package. The leading slash is added if it is missing.
+//
+// in and out may be either of generated messages or *dynamic.Message of github.com/jhump/protoreflect,
+// as long as the codec of the client can marshal them. in is marshaled when the request is sent,
+// and each response is unmarshaled into out, so out must be a non-nil pointer.
+// For streaming APIs, out is reused for every response.
 func NewRequest(
 	endpoint string,
 	in proto.Message,
 	out proto.Message,
 ) *Request {
+	if !strings.HasPrefix(endpoint, "/") {
+		endpoint = "/" + endpoint
+	}
 	return &Request{
 		endpoint: endpoint,
 		in:       in,
@@ -29,7 +45,95 @@ func NewRequest(
 	}
 }
 
+// NewMethodRequest instantiates new API request from passed descriptors and I/O types.
+// Unlike NewRequest, the returned request holds the method descriptor,
+// so that Client.Invoke can determine its API kind,
+// and calling the API as another kind (e.g. Unary for a server streaming API) returns an error.
+func NewMethodRequest(
+	pkg string,
+	s *descriptor.ServiceDescriptorProto,
+	m *descriptor.MethodDescriptorProto,
+	in proto.Message,
+	out proto.Message,
+) *Request {
+	req := NewRequest(ToEndpoint(pkg, s, m), in, out)
+	req.method = m
+	return req
+}
+
+// NewRequestForMethod instantiates new API request for the method named fullMethod in fd, like NewMethodRequest.
+// fullMethod is the fully-qualified method name (e.g. "api.Example.Unary"), or an endpoint (e.g. "/api.Example/Unary").
+func NewRequestForMethod(fd *desc.FileDescriptor, fullMethod string, in, out proto.Message) (*Request, error) {
+	name := strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", -1)
+	i := strings.LastIndex(name, ".")
+	if i == -1 {
+		return nil, errors.Errorf("invalid method name %q, it must be formed like {package}.{service}.{method}", fullMethod)
+	}
+
+	sd := fd.FindService(name[:i])
+	if sd == nil {
+		return nil, errors.Errorf("no such service in %s: %s", fd.GetName(), name[:i])
+	}
+	md := sd.FindMethodByName(name[i+1:])
+	if md == nil {
+		return nil, errors.Errorf("no such method in %s: %s", sd.GetFullyQualifiedName(), name[i+1:])
+	}
+	return NewMethodRequest(fd.GetPackage(), sd.AsServiceDescriptorProto(), md.AsMethodDescriptorProto(), in, out), nil
+}
+
+// Endpoint returns the endpoint of the request. (e.g. "/api.Example/Unary")
+func (r *Request) Endpoint() string {
+	return r.endpoint
+}
+
+// Clone returns a copy of the request which has a deep copy of the input message and a fresh output message.
+// It is useful to send the same request again, e.g. for retries or fan-out,
+// without sharing the output message which is overwritten by each response.
+func (r *Request) Clone() *Request {
+	c := *r
+	if in, ok := r.in.(proto.Message); ok {
+		c.in = proto.Clone(in)
+	}
+	if out, ok := r.out.(proto.Message); ok {
+		m := proto.Clone(out)
+		m.Reset()
+		c.out = m
+	}
+	return &c
+}
+
 // ToEndpoint generates an endpoint from a service descriptor and a method descriptor.
+// pkg may be empty for services without a package, or fully-qualified with the leading dot. (e.g. ".api.v1")
 func ToEndpoint(pkg string, s *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) string {
-	return fmt.Sprintf("/%s.%s/%s", pkg, s.GetName(), m.GetName())
+	service := s.GetName()
+	if pkg = strings.Trim(pkg, "."); pkg != "" {
+		service = pkg + "." + service
+	}
+	return fmt.Sprintf("/%s/%s", service, m.GetName())
+}
+
+// checkKind returns an error if the API kind of the method doesn't match the kind of the call.
+// It does nothing if the request has no method descriptor.
+func (r *Request) checkKind(clientStreaming, serverStreaming bool) error {
+	if r.method == nil {
+		return nil
+	}
+	cs, ss := r.method.GetClientStreaming(), r.method.GetServerStreaming()
+	if cs == clientStreaming && ss == serverStreaming {
+		return nil
+	}
+	return errors.Errorf("%s is a %s API, but it is called as a %s API", r.endpoint, kindName(cs, ss), kindName(clientStreaming, serverStreaming))
+}
+
+func kindName(clientStreaming, serverStreaming bool) string {
+	switch {
+	case !clientStreaming && !serverStreaming:
+		return "unary"
+	case !clientStreaming && serverStreaming:
+		return "server streaming"
+	case clientStreaming && !serverStreaming:
+		return "client streaming"
+	default:
+		return "bidirectional streaming"
+	}
 }
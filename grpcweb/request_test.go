@@ -0,0 +1,99 @@
+package grpcweb
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToEndpoint(t *testing.T) {
+	s := &descriptor.ServiceDescriptorProto{Name: proto.String("Example")}
+	m := &descriptor.MethodDescriptorProto{Name: proto.String("Unary")}
+
+	cases := map[string]struct {
+		pkg      string
+		expected string
+	}{
+		"package":                 {pkg: "api", expected: "/api.Example/Unary"},
+		"nested package":          {pkg: "foo.bar.api", expected: "/foo.bar.api.Example/Unary"},
+		"fully-qualified package": {pkg: ".foo.api", expected: "/foo.api.Example/Unary"},
+		"no package":              {pkg: "", expected: "/Example/Unary"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.expected, ToEndpoint(c.pkg, s, m))
+			assert.Equal(t, c.expected, NewMethodRequest(c.pkg, s, m, nil, nil).Endpoint())
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	cases := map[string]string{
+		"/api.Example/Unary": "/api.Example/Unary",
+		"api.Example/Unary":  "/api.Example/Unary",
+		"Example/Unary":      "/Example/Unary",
+	}
+	for endpoint, expected := range cases {
+		assert.Equal(t, expected, NewRequest(endpoint, nil, nil).Endpoint(), endpoint)
+	}
+}
+
+func TestNewRequestForMethod(t *testing.T) {
+	pkg := getAPIProto(t)
+
+	for _, name := range []string{"api.Example.ServerStreaming", "/api.Example/ServerStreaming"} {
+		req, err := NewRequestForMethod(pkg.FileDescriptor, name, nil, nil)
+		require.NoError(t, err, name)
+		assert.Equal(t, "/api.Example/ServerStreaming", req.Endpoint())
+		assert.NoError(t, req.checkKind(false, true), "the request must hold the method descriptor")
+		assert.Error(t, req.checkKind(false, false))
+	}
+
+	for _, name := range []string{"Unary", "api.Foo.Unary", "api.Example.Foo"} {
+		_, err := NewRequestForMethod(pkg.FileDescriptor, name, nil, nil)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRequestClone(t *testing.T) {
+	md, err := desc.LoadMessageDescriptorForMessage(&wrappers.StringValue{})
+	require.NoError(t, err)
+	newDynamic := func(v string) proto.Message {
+		m := dynamic.NewMessage(md)
+		m.SetFieldByName("value", v)
+		return m
+	}
+
+	cases := map[string]struct {
+		in, out proto.Message
+	}{
+		"generated messages": {in: &wrappers.StringValue{Value: "in"}, out: &wrappers.StringValue{Value: "stale"}},
+		"dynamic messages":   {in: newDynamic("in"), out: newDynamic("stale")},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			m := &descriptor.MethodDescriptorProto{Name: proto.String("Unary")}
+			req := NewMethodRequest("api", &descriptor.ServiceDescriptorProto{Name: proto.String("Example")}, m, c.in, c.out)
+			clone := req.Clone()
+
+			assert.Equal(t, req.Endpoint(), clone.Endpoint())
+			assert.Equal(t, m, clone.method)
+			assert.True(t, proto.Equal(c.in, clone.in.(proto.Message)))
+			assert.False(t, c.in == clone.in, "the input message must be copied")
+			assert.False(t, c.out == clone.out, "the output message must be fresh")
+			assert.Equal(t, "", proto.CompactTextString(clone.out.(proto.Message)))
+
+			// the clone is independent of the original request.
+			clone.in.(proto.Message).Reset()
+			assert.NotEqual(t, "", proto.CompactTextString(c.in))
+		})
+	}
+}
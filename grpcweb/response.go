@@ -7,4 +7,8 @@ package grpcweb
 type Response struct {
 	ContentType string
 	Content     interface{}
+
+	// TrailersOnly reports whether the response has no messages and only the status was sent.
+	// (trailers-only response) Content is left as the zero value in that case.
+	TrailersOnly bool
 }
@@ -0,0 +1,74 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatsHandler observes the start and the end of calls, e.g. to collect metrics such as Prometheus collectors.
+// Implementations must be safe for concurrent use.
+type StatsHandler interface {
+	// OnCallStart is called when a call to endpoint starts.
+	OnCallStart(endpoint string)
+
+	// OnCallEnd is called once when the call ends, with its status code and duration.
+	// Errors without a status, such as transport errors, are reported as Unknown.
+	OnCallEnd(endpoint string, code codes.Code, d time.Duration)
+}
+
+// WithStatsHandler registers a StatsHandler which observes every call of the client.
+//
+// A unary call ends when Unary returns. A stream ends when it receives the trailer or an error,
+// or when it is closed by Cancel, CloseAndReceive or Close. Streams closed before the trailer are reported as Canceled.
+// Client streams start when the first request is sent.
+func WithStatsHandler(h StatsHandler) ClientOption {
+	return func(c *Client) {
+		c.statsHandler = h
+	}
+}
+
+// callStats reports a call to the StatsHandler. nil callStats does nothing.
+type callStats struct {
+	h        StatsHandler
+	endpoint string
+	start    time.Time
+	once     sync.Once
+}
+
+// startCall reports the start of a call to endpoint. It returns nil if the client has no StatsHandler.
+func (c *Client) startCall(endpoint string) *callStats {
+	if c.statsHandler == nil {
+		return nil
+	}
+	c.statsHandler.OnCallStart(endpoint)
+	return &callStats{h: c.statsHandler, endpoint: endpoint, start: time.Now()}
+}
+
+// end reports the end of the call by err. Only the first call of end is reported.
+func (s *callStats) end(err error) {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.h.OnCallEnd(s.endpoint, errorCode(err), time.Since(s.start))
+	})
+}
+
+// errorCode returns the status code of err. nil and io.EOF mean OK.
+func errorCode(err error) codes.Code {
+	switch err = errors.Cause(err); err {
+	case nil, io.EOF:
+		return codes.OK
+	case context.Canceled:
+		return codes.Canceled
+	case context.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	}
+	return status.Code(err)
+}
@@ -0,0 +1,104 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+type statsHandler struct {
+	m      sync.Mutex
+	events []string
+}
+
+func (h *statsHandler) OnCallStart(endpoint string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.events = append(h.events, "start "+endpoint)
+}
+
+func (h *statsHandler) OnCallEnd(endpoint string, code codes.Code, d time.Duration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.events = append(h.events, "end "+endpoint+" "+code.String())
+}
+
+func TestWithStatsHandler(t *testing.T) {
+	ok := frame(0x80, []byte("grpc-status: 0\r\n"))
+	notFound := frame(0x80, []byte("grpc-status: 5\r\n"))
+	newRequest := func(endpoint string) *Request {
+		return NewRequest(endpoint, &wrappers.StringValue{}, &wrappers.StringValue{})
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		for res, code := range map[string]string{string(ok): "OK", string(notFound): "NotFound"} {
+			var h statsHandler
+			client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: []byte(res)}, nil), WithStatsHandler(&h))
+			client.Unary(context.Background(), newRequest("/api.Example/Unary"))
+			assert.Equal(t, []string{"start /api.Example/Unary", "end /api.Example/Unary " + code}, h.events)
+		}
+	})
+
+	t.Run("server streaming", func(t *testing.T) {
+		var h statsHandler
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: ok}, nil), WithStatsHandler(&h))
+		s, err := client.ServerStreaming(context.Background(), newRequest("/api.Example/ServerStreaming"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"start /api.Example/ServerStreaming"}, h.events)
+
+		_, err = s.Receive()
+		assert.Equal(t, io.EOF, err)
+		s.(ServerStream).Cancel()
+		assert.Equal(t, []string{"start /api.Example/ServerStreaming", "end /api.Example/ServerStreaming OK"}, h.events, "the end must be reported only once")
+	})
+
+	t.Run("canceled server streaming", func(t *testing.T) {
+		var h statsHandler
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: ok}, nil), WithStatsHandler(&h))
+		s, err := client.ServerStreaming(context.Background(), newRequest("/api.Example/ServerStreaming"))
+		require.NoError(t, err)
+
+		s.(ServerStream).Cancel()
+		assert.Equal(t, []string{"start /api.Example/ServerStreaming", "end /api.Example/ServerStreaming Canceled"}, h.events)
+	})
+
+	t.Run("client streaming", func(t *testing.T) {
+		var h statsHandler
+		client := NewClient(defaultAddr, withStubTransport(nil, &stubStreamTransport{res: notFound}), WithStatsHandler(&h))
+		s, err := client.ClientStreaming(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, h.events, "client streams start on the first Send")
+
+		require.NoError(t, s.Send(newRequest("/api.Example/ClientStreaming")))
+		_, err = s.CloseAndReceive()
+		assert.Error(t, err)
+		assert.Equal(t, []string{"start /api.Example/ClientStreaming", "end /api.Example/ClientStreaming NotFound"}, h.events)
+	})
+
+	t.Run("bidi streaming", func(t *testing.T) {
+		var h statsHandler
+		client := NewClient(defaultAddr, withStubTransport(nil, &stubStreamTransport{res: ok}), WithStatsHandler(&h))
+
+		s, err := client.BidiStreaming(context.Background(), newRequest("/api.Example/BidiStreaming"))
+		require.NoError(t, err)
+		_, err = s.Receive()
+		assert.Equal(t, io.EOF, err)
+		s.Close()
+
+		s, err = client.BidiStreaming(context.Background(), newRequest("/api.Example/BidiStreaming"))
+		require.NoError(t, err)
+		s.Close()
+
+		assert.Equal(t, []string{
+			"start /api.Example/BidiStreaming", "end /api.Example/BidiStreaming OK",
+			"start /api.Example/BidiStreaming", "end /api.Example/BidiStreaming Canceled",
+		}, h.events)
+	})
+}
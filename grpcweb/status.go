@@ -0,0 +1,201 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// parseTrailer parses the content of a trailer frame.
+// The trailer is formed like HTTP/1 headers. (e.g. "grpc-status: 0\r\ngrpc-message: \r\n")
+// Header names are lowercased, and values of repeated names are accumulated in order.
+func parseTrailer(b []byte) metadata.MD {
+	md := metadata.MD{}
+	for _, line := range bytes.Split(b, []byte("\r\n")) {
+		i := bytes.IndexByte(line, ':')
+		if i == -1 {
+			continue
+		}
+		k := strings.ToLower(string(bytes.TrimSpace(line[:i])))
+		v := string(bytes.TrimSpace(line[i+1:]))
+		md[k] = append(md[k], v)
+	}
+	return md
+}
+
+// trailerFrame encodes md into a trailer frame, which is the inverse of parseTrailer.
+// Values are written as is, so grpc-message must be percent-encoded already (see statusTrailer).
+// Names are lowercased and sorted so that the frame is deterministic.
+func trailerFrame(md metadata.MD) []byte {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for _, k := range keys {
+		for _, v := range md[k] {
+			fmt.Fprintf(&b, "%s: %s\r\n", strings.ToLower(k), v)
+		}
+	}
+
+	f := make([]byte, headerLen, headerLen+b.Len())
+	f[0] = flagTrailer
+	binary.BigEndian.PutUint32(f[1:], uint32(b.Len()))
+	return append(f, b.Bytes()...)
+}
+
+// statusTrailer returns the trailer which has the status of code and msg.
+// msg is percent-encoded, and it is omitted if it is empty.
+func statusTrailer(code codes.Code, msg string) metadata.MD {
+	md := metadata.Pairs("grpc-status", strconv.Itoa(int(code)))
+	if msg != "" {
+		md.Set("grpc-message", url.PathEscape(msg))
+	}
+	return md
+}
+
+// metadataCallback receives metadata frames in streams. nil metadataCallback does nothing.
+type metadataCallback func(metadata.MD)
+
+// handle reports whether the frame is a metadata frame interleaved between messages.
+// Unlike the trailer at the end of the stream, such frames don't have grpc-status.
+// If the frame is a metadata frame, handle passes it to the callback.
+func (f metadataCallback) handle(flag byte, b []byte) bool {
+	if flag&flagTrailer == 0 {
+		return false
+	}
+	md := parseTrailer(b)
+	if _, ok := md["grpc-status"]; ok {
+		return false
+	}
+	if f != nil {
+		f(md)
+	}
+	return true
+}
+
+// trailerValidator validates the trailer of a response. nil trailerValidator accepts any trailers.
+type trailerValidator func(metadata.MD) error
+
+func (f trailerValidator) validate(md metadata.MD) error {
+	if f == nil {
+		return nil
+	}
+	return f(md)
+}
+
+// endOfStream returns the error which ends a stream by the trailer frame.
+// It is io.EOF if the status is OK, otherwise the status error.
+func endOfStream(b []byte) error {
+	if err := statusFromTrailer(parseTrailer(b), false); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// hasStatus reports whether md has grpc-status.
+// If the response headers have it, the response is trailers-only and the status is sent in the headers.
+func hasStatus(md metadata.MD) bool {
+	_, ok := md["grpc-status"]
+	return ok
+}
+
+// wrapError wraps err with msg unless err has a status, so that status.Code can inspect it.
+func wrapError(err error, msg string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return errors.Wrap(err, msg)
+}
+
+// contextError converts errors caused by the context to status errors like grpc-go.
+// context.DeadlineExceeded becomes DeadlineExceeded, and context.Canceled becomes Canceled.
+// Other errors are returned as is.
+func contextError(err error) error {
+	cause := errors.Cause(err)
+	// net/http wraps the context error with *url.Error.
+	if e, ok := cause.(*url.Error); ok {
+		cause = e.Err
+	}
+	switch cause {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return err
+}
+
+// statusFromTrailer converts grpc-status and grpc-message in the trailer to an error.
+// An empty or absent grpc-status means OK.
+// If strict is true, an absent grpc-status results in an error.
+func statusFromTrailer(md metadata.MD, strict bool) error {
+	v, ok := md["grpc-status"]
+	if !ok || len(v) == 0 {
+		if strict {
+			return status.Error(codes.Internal, "grpc-status is missing in the response")
+		}
+		return nil
+	}
+	if v[0] == "" {
+		return nil
+	}
+
+	code, err := strconv.ParseUint(v[0], 10, 32)
+	if err != nil {
+		return status.Errorf(codes.Internal, "malformed grpc-status: %s", v[0])
+	}
+
+	var msg string
+	if m, ok := md["grpc-message"]; ok && len(m) != 0 {
+		msg = m[0]
+		// grpc-message is percent-encoded.
+		if s, err := url.PathUnescape(msg); err == nil {
+			msg = s
+		}
+	}
+
+	if v, ok := md["grpc-status-details-bin"]; ok && len(v) != 0 {
+		st, err := decodeStatusDetails(v[0])
+		if err != nil {
+			return status.Errorf(codes.Internal, "malformed grpc-status-details-bin: %s", err)
+		}
+		return status.FromProto(st).Err()
+	}
+
+	return status.New(codes.Code(code), msg).Err()
+}
+
+// decodeStatusDetails decodes grpc-status-details-bin which is a base64 encoded google.rpc.Status.
+// The padding of base64 may be omitted.
+func decodeStatusDetails(v string) (*spb.Status, error) {
+	enc := base64.StdEncoding
+	if len(v)%4 != 0 {
+		enc = base64.RawStdEncoding
+	}
+	b, err := enc.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	var st spb.Status
+	if err := proto.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,130 @@
+package grpcweb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseTrailer(t *testing.T) {
+	b := []byte("grpc-status: 0\r\nSet-Cookie: a=1\r\nx-foo: bar\r\nset-cookie: b=2; Path=/\r\n\r\n")
+	expected := metadata.MD{
+		"grpc-status": {"0"},
+		"set-cookie":  {"a=1", "b=2; Path=/"},
+		"x-foo":       {"bar"},
+	}
+	assert.Equal(t, expected, parseTrailer(b))
+
+	t.Run("Trailer CallOption", func(t *testing.T) {
+		client := NewClient(defaultAddr, withStubTransport(&stubTransport{res: frame(flagTrailer, b)}, nil))
+		var trailer metadata.MD
+		_, err := client.Unary(context.Background(), NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{}), Trailer(&trailer))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a=1", "b=2; Path=/"}, trailer.Get("set-cookie"))
+	})
+}
+
+func TestTrailerFrameEncoding(t *testing.T) {
+	md := metadata.MD{
+		"Grpc-Status": {"0"},
+		"set-cookie":  {"a=1", "b=2; Path=/"},
+	}
+	f := trailerFrame(md)
+	assert.Equal(t, frame(flagTrailer, []byte("grpc-status: 0\r\nset-cookie: a=1\r\nset-cookie: b=2; Path=/\r\n")), f)
+	assert.Equal(t, metadata.MD{"grpc-status": {"0"}, "set-cookie": {"a=1", "b=2; Path=/"}}, parseTrailer(f[headerLen:]))
+
+	t.Run("statusTrailer escapes the message", func(t *testing.T) {
+		md := statusTrailer(codes.Internal, "100% broken\r\n")
+		assert.Equal(t, []string{"13"}, md.Get("grpc-status"))
+		assert.Equal(t, []string{url.PathEscape("100% broken\r\n")}, md.Get("grpc-message"))
+		assert.Empty(t, statusTrailer(codes.OK, "").Get("grpc-message"))
+	})
+}
+
+func TestStatusFromTrailer(t *testing.T) {
+	t.Run("grpc-status-details-bin", func(t *testing.T) {
+		detail := &descriptor.DescriptorProto{Name: p("field")}
+		st, err := status.New(codes.InvalidArgument, "invalid field").WithDetails(detail)
+		require.NoError(t, err)
+		b, err := proto.Marshal(st.Proto())
+		require.NoError(t, err)
+
+		for name, enc := range map[string]*base64.Encoding{"padded": base64.StdEncoding, "unpadded": base64.RawStdEncoding} {
+			t.Run(name, func(t *testing.T) {
+				md := metadata.Pairs(
+					"grpc-status", "3",
+					"grpc-message", "invalid%20field",
+					"grpc-status-details-bin", enc.EncodeToString(b),
+				)
+
+				actual := status.Convert(statusFromTrailer(md, false))
+				assert.Equal(t, codes.InvalidArgument, actual.Code())
+				assert.Equal(t, "invalid field", actual.Message())
+				require.Len(t, actual.Details(), 1)
+				assert.True(t, proto.Equal(detail, actual.Details()[0].(proto.Message)))
+			})
+		}
+	})
+
+	t.Run("malformed grpc-status-details-bin", func(t *testing.T) {
+		md := metadata.Pairs("grpc-status", "3", "grpc-status-details-bin", "!!!")
+		assert.Equal(t, codes.Internal, status.Code(statusFromTrailer(md, false)))
+	})
+}
+
+func TestContextError(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		code codes.Code
+	}{
+		"deadline exceeded": {err: context.DeadlineExceeded, code: codes.DeadlineExceeded},
+		"canceled":          {err: context.Canceled, code: codes.Canceled},
+		"wrapped":           {err: pkgerrors.Wrap(context.Canceled, "failed to send"), code: codes.Canceled},
+		"url.Error":         {err: pkgerrors.Wrap(&url.Error{Op: "Post", URL: "http://localhost", Err: context.DeadlineExceeded}, "failed to send"), code: codes.DeadlineExceeded},
+		"other errors":      {err: errors.New("foo"), code: codes.Unknown},
+		"status errors":     {err: status.Error(codes.NotFound, "missing"), code: codes.NotFound},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.code, status.Code(contextError(c.err)))
+		})
+	}
+
+	t.Run("Unary", func(t *testing.T) {
+		done := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer srv.Close()
+		defer close(done)
+		client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+		req := NewRequest("/api.Example/Unary", &wrappers.StringValue{}, &wrappers.StringValue{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := client.Unary(ctx, req)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "%v", err)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, err = client.Unary(ctx, req)
+		assert.Equal(t, codes.Canceled, status.Code(err), "%v", err)
+	})
+}
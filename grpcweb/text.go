@@ -0,0 +1,84 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// isTextContentType reports whether ct is a content-type of grpc-web-text, which encodes bodies in base64.
+func isTextContentType(ct string) bool {
+	return strings.HasPrefix(strings.ToLower(ct), "application/grpc-web-text")
+}
+
+// textReader decodes a grpc-web-text body, which is a base64 stream of frames including the trailer.
+// Some gateways concatenate separately encoded chunks, so padding may appear in the middle of the stream.
+// Since each padded chunk is a multiple of 4 characters, textReader decodes the stream per 4 characters quantum.
+type textReader struct {
+	r   io.Reader
+	buf [4096]byte
+
+	// enc is base64 characters which are not decoded yet. It is shorter than a quantum.
+	enc []byte
+	// dec is decoded bytes which are not read yet. It shares the backing array with out.
+	dec []byte
+	out []byte
+	err error
+}
+
+func newTextReader(r io.Reader) *textReader {
+	return &textReader{r: r}
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	for len(t.dec) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		t.fill()
+	}
+	n := copy(p, t.dec)
+	t.dec = t.dec[n:]
+	return n, nil
+}
+
+// fill reads base64 characters from the underlying reader and decodes complete quanta.
+func (t *textReader) fill() {
+	n, err := t.r.Read(t.buf[:])
+	for _, c := range t.buf[:n] {
+		switch c {
+		case '\r', '\n', ' ', '\t':
+		default:
+			t.enc = append(t.enc, c)
+		}
+	}
+
+	// fill is called only if dec is empty, so out can be reused.
+	t.out = t.out[:0]
+	var q [3]byte
+	i := 0
+	for ; i+4 <= len(t.enc); i += 4 {
+		m, derr := base64.StdEncoding.Decode(q[:], t.enc[i:i+4])
+		if derr != nil {
+			t.err = errors.Wrap(derr, "failed to decode the grpc-web-text body")
+			return
+		}
+		t.out = append(t.out, q[:m]...)
+	}
+	t.enc = t.enc[:copy(t.enc, t.enc[i:])]
+
+	if err == io.EOF && len(t.enc) > 0 {
+		// the last chunk may omit the padding.
+		m, derr := base64.RawStdEncoding.Decode(q[:], t.enc)
+		if derr != nil {
+			t.err = errors.Wrap(derr, "failed to decode the grpc-web-text body")
+			return
+		}
+		t.out = append(t.out, q[:m]...)
+		t.enc = t.enc[:0]
+	}
+	t.dec = t.out
+	t.err = err
+}
@@ -0,0 +1,64 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextReader(t *testing.T) {
+	message, trailer := frame(0x00, []byte("hello")), frame(0x80, []byte("grpc-status: 0\r\n"))
+	body := string(message) + string(trailer)
+	enc := base64.StdEncoding.EncodeToString
+
+	cases := map[string]string{
+		"one stream":         enc([]byte(body)),
+		"separate chunks":    enc(message) + enc(trailer),
+		"many small chunks":  enc(message[:1]) + enc(message[1:]) + enc(trailer[:2]) + enc(trailer[2:]),
+		"line breaks":        enc(message) + "\r\n" + enc(trailer) + "\r\n",
+		"unpadded last part": enc(message) + base64.RawStdEncoding.EncodeToString(trailer),
+	}
+	for name, in := range cases {
+		in := in
+		t.Run(name, func(t *testing.T) {
+			for _, r := range []struct {
+				name string
+				wrap func(r *strings.Reader) *textReader
+			}{
+				{"", func(r *strings.Reader) *textReader { return newTextReader(r) }},
+				{"one byte reads", func(r *strings.Reader) *textReader { return newTextReader(iotest.OneByteReader(r)) }},
+			} {
+				b, err := ioutil.ReadAll(r.wrap(strings.NewReader(in)))
+				require.NoError(t, err, r.name)
+				assert.Equal(t, body, string(b), r.name)
+			}
+		})
+	}
+
+	t.Run("frames", func(t *testing.T) {
+		r := newTextReader(strings.NewReader(enc(message) + enc(trailer)))
+
+		flag, b, err := readFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x00), flag)
+		assert.Equal(t, "hello", string(b))
+
+		flag, b, err = readFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x80), flag)
+		assert.Equal(t, "grpc-status: 0\r\n", string(b))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ioutil.ReadAll(newTextReader(strings.NewReader("AA!A")))
+		assert.Error(t, err)
+
+		_, err = ioutil.ReadAll(newTextReader(strings.NewReader("AAAAA")))
+		assert.Error(t, err)
+	})
+}
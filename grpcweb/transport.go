@@ -3,23 +3,292 @@ package grpcweb
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type (
 	TransportBuilder       func(host string, req *Request) Transport
 	StreamTransportBuilder func(host string, endpoint string) (StreamTransport, error)
+
+	// TransportBuilderWithOptions is a TransportBuilder which also receives TransportOptions configured by ClientOptions.
+	TransportBuilderWithOptions func(host string, req *Request, opts *TransportOptions) Transport
+	// StreamTransportBuilderWithOptions is a StreamTransportBuilder which also receives TransportOptions configured by ClientOptions.
+	// The stream must be closed when ctx is done.
+	StreamTransportBuilderWithOptions func(ctx context.Context, host string, endpoint string, opts *TransportOptions) (StreamTransport, error)
 )
 
+// TransportOptions holds connection settings passed to TransportBuilderWithOptions and StreamTransportBuilderWithOptions.
+// It is configured by ClientOptions such as WithInsecure and WithTLSConfig.
+type TransportOptions struct {
+	// Insecure disables transport security, so that transports use http and ws instead of https and wss.
+	// If Insecure is true, TLSConfig is ignored.
+	Insecure bool
+
+	// TLSConfig is the TLS configuration used by secure transports.
+	// If nil, the default configuration is used.
+	TLSConfig *tls.Config
+
+	// InsecureSkipVerify makes secure transports accept any server certificate, in addition to TLSConfig.
+	// It is only for development, e.g. against gateways with self-signed certificates.
+	InsecureSkipVerify bool
+
+	// ContentType is the content-type of requests.
+	// If empty, "application/grpc-web+proto" is used.
+	// If it is a gRPC content-type such as "application/grpc", HTTP transports talk gRPC over HTTP/2
+	// and read the status from HTTP trailers instead of the trailer frame.
+	ContentType string
+
+	// HTTP2 makes HTTP transports always speak HTTP/2, even for gRPC Web.
+	// If Insecure is also true, they speak HTTP/2 over cleartext TCP (h2c) with prior knowledge.
+	HTTP2 bool
+
+	// Compression is the name of the compressor of request messages, which is sent as grpc-encoding header.
+	// If empty, messages are not compressed.
+	Compression string
+
+	// AcceptCompression is the names of compressors which can decompress response messages, in addition to Compression.
+	// They are sent as grpc-accept-encoding header, so that the server can choose one of them.
+	AcceptCompression []string
+
+	// BasePath is prepended to the method path of requests, for servers mounted under a path. (e.g. "/grpc")
+	BasePath string
+
+	// Authority overrides the Host header of unary requests and WebSocket opening handshakes,
+	// while transports still connect to the host passed to the builders. If empty, the host is used.
+	Authority string
+
+	// FollowRedirects makes HTTP transports follow redirect responses by re-sending the request
+	// with the same method, body and headers. Credentials such as Authorization header are sent only to the same host.
+	// If false, a redirect results in RedirectError.
+	FollowRedirects bool
+
+	// XGRPCWeb is the value of x-grpc-web header of requests.
+	// If empty, "1" is used.
+	XGRPCWeb string
+
+	// DisableXGRPCWeb stops sending x-grpc-web header in both of HTTP and WebSocket transports. XGRPCWeb is ignored.
+	DisableXGRPCWeb bool
+
+	// DisableTETrailers stops sending "te: trailers" header in gRPC Web requests.
+	// gRPC requests always have it.
+	DisableTETrailers bool
+
+	// DrainTimeout bounds how long closing a stream transport waits for draining remaining frames.
+	// If zero, the connection is closed immediately.
+	DrainTimeout time.Duration
+
+	// UserAgent is the User-Agent header of unary requests and WebSocket opening handshakes.
+	// If empty, "grpc-web-go-client/{Version}" is used.
+	UserAgent string
+
+	// WebSocketSubprotocol is the subprotocol requested in the WebSocket opening handshake.
+	// If empty, "grpc-websockets" is used.
+	WebSocketSubprotocol string
+
+	// WebSocketHeader is extra headers sent in the WebSocket opening handshake. (e.g. Origin)
+	WebSocketHeader http.Header
+
+	// WebSocketMaxMessageSize is the max size of each WebSocket message sent by Send of stream transports.
+	// Larger requests are split into multiple messages, which the server concatenates into the request stream.
+	// It must be larger than 1 because each message has a leading byte. If zero, requests are not split.
+	WebSocketMaxMessageSize int
+
+	// ResponseHeaderTimeout bounds how long HTTP transports wait for the response headers after sending a request.
+	// It doesn't limit reading the response body. If zero, there is no timeout.
+	ResponseHeaderTimeout time.Duration
+
+	// DialTimeout bounds how long stream transports wait for establishing a connection, including the handshake.
+	// If zero, there is no timeout.
+	DialTimeout time.Duration
+
+	// KeepaliveInterval is the interval of WebSocket ping messages which keep stream connections alive.
+	// If zero, ping messages are not sent.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveTimeout bounds how long stream transports wait for the pong message.
+	// If the pong doesn't arrive, the connection is closed. If zero, KeepaliveInterval is used.
+	KeepaliveTimeout time.Duration
+
+	// SendTimeout bounds how long each Send of stream transports waits for writing a message.
+	// If zero, Send may block until the message is written.
+	SendTimeout time.Duration
+
+	// ErrorMapper converts responses which HTTPTransport can't handle as gRPC Web responses into errors,
+	// i.e. responses with a non-200 HTTP status or an unexpected content-type. body is the response body.
+	// If it returns nil, HTTPStatusError or ContentTypeError is returned as usual.
+	ErrorMapper func(res *http.Response, body []byte) error
+
+	// RoundTripper sends requests of HTTP transports instead of the default one, e.g. an HTTP/3 round tripper.
+	// If it is specified, TLSConfig and HTTP2 are ignored by HTTP transports. The framing is the same.
+	RoundTripper http.RoundTripper
+
+	// HTTPClient is shared between HTTP transports built by the same Client,
+	// so that connections are reused across requests.
+	// If nil, HTTPTransportBuilderWithOptions creates a new one for each request.
+	HTTPClient *http.Client
+}
+
+func (o *TransportOptions) contentType() string {
+	if o.ContentType == "" {
+		return "application/grpc-web+proto"
+	}
+	return o.ContentType
+}
+
+// path returns the request path of endpoint, prefixed with BasePath.
+func (o *TransportOptions) path(endpoint string) string {
+	base := strings.TrimSuffix(o.BasePath, "/")
+	if base != "" && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+	return base + endpoint
+}
+
+// acceptEncoding returns the value of grpc-accept-encoding header. It is empty if no compressors are accepted.
+func (o *TransportOptions) acceptEncoding() string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range append([]string{o.Compression}, o.AcceptCompression...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+// tlsConfig returns the TLS configuration of secure transports.
+func (o *TransportOptions) tlsConfig() *tls.Config {
+	if !o.InsecureSkipVerify {
+		return o.TLSConfig
+	}
+	cfg := &tls.Config{}
+	if o.TLSConfig != nil {
+		cfg = o.TLSConfig.Clone()
+	}
+	cfg.InsecureSkipVerify = true
+	return cfg
+}
+
+// xGRPCWeb returns the value of x-grpc-web header. It is empty if the header is disabled.
+func (o *TransportOptions) xGRPCWeb() string {
+	if o.DisableXGRPCWeb {
+		return ""
+	}
+	if o.XGRPCWeb == "" {
+		return "1"
+	}
+	return o.XGRPCWeb
+}
+
+func (o *TransportOptions) userAgent() string {
+	if o.UserAgent == "" {
+		return "grpc-web-go-client/" + Version
+	}
+	return o.UserAgent
+}
+
+func (o *TransportOptions) webSocketSubprotocol() string {
+	if o.WebSocketSubprotocol == "" {
+		return "grpc-websockets"
+	}
+	return o.WebSocketSubprotocol
+}
+
+func (o *TransportOptions) isGRPC() bool {
+	return isGRPCContentType(o.contentType())
+}
+
+// isGRPCContentType reports whether ct is a content-type of gRPC, not gRPC Web.
+func isGRPCContentType(ct string) bool {
+	ct = strings.ToLower(ct)
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;")
+}
+
+// isUnexpectedContentType reports whether ct is the content-type of a response which is not gRPC Web nor gRPC,
+// such as text/html or application/json.
+// Responses without content-type, or with application/octet-stream which doesn't declare any format, are accepted.
+func isUnexpectedContentType(ct string) bool {
+	if ct == "" || isGRPCContentType(ct) {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	return err != nil || mt != "application/octet-stream"
+}
+
+// newHTTPClient instantiates a HTTP client which keeps connections alive.
+// Its settings are same as http.DefaultTransport's except for TLS.
+// If opts specifies gRPC or HTTP2, the client always speaks HTTP/2. If opts specifies RoundTripper, the client uses it.
+func newHTTPClient(opts *TransportOptions) *http.Client {
+	if opts.RoundTripper != nil {
+		return &http.Client{Transport: opts.RoundTripper, CheckRedirect: noRedirect}
+	}
+	if opts.isGRPC() || opts.HTTP2 {
+		return newHTTP2Client(opts)
+	}
+
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if !opts.Insecure {
+		t.TLSClientConfig = opts.tlsConfig()
+	}
+	return &http.Client{Transport: t, CheckRedirect: noRedirect}
+}
+
+// noRedirect stops http.Client following redirects, which changes POST requests to GET requests without bodies.
+// HTTP transports handle redirects by themselves.
+func noRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// newHTTP2Client instantiates a HTTP/2 client.
+// If opts.Insecure is true, it speaks HTTP/2 over cleartext TCP (h2c) with prior knowledge.
+func newHTTP2Client(opts *TransportOptions) *http.Client {
+	t := &http2.Transport{}
+	if opts.Insecure {
+		t.AllowHTTP = true
+		t.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}
+	} else {
+		t.TLSClientConfig = opts.tlsConfig()
+	}
+	return &http.Client{Transport: t, CheckRedirect: noRedirect}
+}
+
+// DefaultTransportBuilder and DefaultStreamTransportBuilder build transports without TransportOptions.
+// Client uses HTTPTransportBuilderWithOptions and WebSocketTransportBuilderWithOptions by default instead,
+// so that ClientOptions such as WithTLSConfig are applied.
 var (
 	DefaultTransportBuilder       TransportBuilder       = HTTPTransportBuilder
 	DefaultStreamTransportBuilder StreamTransportBuilder = WebSocketTransportBuilder
@@ -27,71 +296,556 @@ var (
 
 var (
 	ErrConnectionClosed = errors.New("connection closed")
+
+	// ErrSendAfterCloseSend is returned when Send is called on a stream transport after CloseSend.
+	ErrSendAfterCloseSend = errors.New("Send must not be called after CloseSend")
+
+	// ErrTransportReused is returned when Send is called more than once on a unary transport.
+	// A transport is built per request, so build a new one to send the request again.
+	ErrTransportReused = errors.New("Send must be called only one time per one Request")
 )
 
+// maxErrorBodySnippet is the max length of the response body which HTTPStatusError holds.
+const maxErrorBodySnippet = 512
+
+// HTTPStatusError is returned when a gateway responds with a non-200 HTTP status code.
+// It usually means that the request didn't reach the gRPC Web server. (e.g. 502 Bad Gateway or 401 Unauthorized)
+type HTTPStatusError struct {
+	StatusCode int
+
+	// Body is the beginning of the response body.
+	Body []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d %s: %q", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// RedirectError is returned when a server responds with a redirect (e.g. from http to https),
+// and WithFollowRedirects is not specified or the redirect can't be followed.
+type RedirectError struct {
+	StatusCode int
+
+	// Location is the URL of the redirect target. It is empty if the response has no Location header.
+	Location string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("unexpected redirect %d %s to %q", e.StatusCode, http.StatusText(e.StatusCode), e.Location)
+}
+
+// ContentTypeError is returned when a server responds with a content-type which is neither gRPC Web nor gRPC.
+// It usually means that a proxy doesn't forward requests to the gRPC Web server. (e.g. text/html error pages)
+type ContentTypeError struct {
+	ContentType string
+	// Body is the beginning of the response body, up to 512 bytes.
+	Body []byte
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("unexpected content-type %q of the response, it must be application/grpc-web or application/grpc: %q", e.ContentType, e.Body)
+}
+
+// headerKey is the context key for request headers which are attached by Client per call.
+type headerKey struct{}
+
+// withHeader returns a copy of ctx which carries md as request headers.
+// md is merged with headers which are already attached to ctx.
+func withHeader(ctx context.Context, md metadata.MD) context.Context {
+	if old, ok := ctx.Value(headerKey{}).(metadata.MD); ok {
+		md = metadata.Join(old, md)
+	}
+	return context.WithValue(ctx, headerKey{}, md)
+}
+
+// HeaderFromContext returns request headers which are attached to ctx by Client for the call.
+// Transport implementations should send them as request headers.
+func HeaderFromContext(ctx context.Context) metadata.MD {
+	md, _ := ctx.Value(headerKey{}).(metadata.MD)
+	return md
+}
+
 // Transport creates new request.
 // Transport is created only one per one request, MUST not use used transport again.
 type Transport interface {
 	Send(ctx context.Context, body io.Reader) (io.ReadCloser, error)
+
+	// Header returns the response headers.
+	// It must be called after Send returns.
+	Header() metadata.MD
 }
 
 type HTTPTransport struct {
 	sent bool
 
-	host   string
-	req    *Request
-	client *http.Client
+	host      string
+	authority string
+	path      string
+	req       *Request
+	client    *http.Client
+
+	insecure    bool
+	contentType string
+	xGRPCWeb    string
+	userAgent   string
+	compression string
+	grpc        bool
+	text        bool // grpc-web-text, which encodes bodies in base64
 
-	insecure bool
+	// acceptEncoding is the value of grpc-accept-encoding header.
+	acceptEncoding string
+	errorMapper    func(*http.Response, []byte) error
+
+	disableTETrailers     bool
+	followRedirects       bool
+	responseHeaderTimeout time.Duration
+
+	header metadata.MD
 }
 
 func (t *HTTPTransport) Send(ctx context.Context, body io.Reader) (io.ReadCloser, error) {
 	if t.sent {
-		return nil, errors.New("Send must be called only one time per one Request")
+		return nil, ErrTransportReused
 	}
 	defer func() {
 		t.sent = true
 	}()
 
-	// TODO: insecure option
-	protocol := "http"
+	protocol := "https"
+	if t.insecure {
+		protocol = "http"
+	}
+
+	if t.text {
+		// grpc-web-text sends the whole framed body in base64.
+		b, err := ioutil.ReadAll(body)
+		if c, ok := body.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the request body")
+		}
+		body = strings.NewReader(base64.StdEncoding.EncodeToString(b))
+	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s://%s%s", protocol, t.host, t.req.endpoint), body)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s://%s%s", protocol, t.host, t.path), body)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to build the API request")
 	}
+	if t.authority != "" {
+		req.Host = t.authority
+	}
+	// http.NewRequest doesn't know the length of pooled bodies, and how to replay them for retries.
+	if b, ok := body.(*requestBody); ok {
+		req.ContentLength = int64(b.size)
+		req.GetBody = func() (io.ReadCloser, error) {
+			return b.body.reader(), nil
+		}
+	}
 
-	req.Header.Add("content-type", "application/grpc-web+proto")
-	req.Header.Add("x-grpc-web", "1")
+	req.Header.Add("content-type", t.contentType)
+	req.Header.Set("user-agent", t.userAgent)
+	if !t.grpc && t.xGRPCWeb != "" {
+		req.Header.Add("x-grpc-web", t.xGRPCWeb)
+	}
+	if t.text {
+		req.Header.Set("accept", t.contentType)
+	}
+	// gRPC servers require it to detect incompatible proxies, and some gateways also expect it.
+	if t.grpc || !t.disableTETrailers {
+		req.Header.Add("te", "trailers")
+	}
+	if t.compression != "" {
+		req.Header.Set("grpc-encoding", t.compression)
+	}
+	if t.acceptEncoding != "" {
+		req.Header.Set("grpc-accept-encoding", t.acceptEncoding)
+	}
+	for k, vs := range HeaderFromContext(ctx) {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			// don't send the request which the server treats as already expired.
+			return nil, errors.Wrap(context.DeadlineExceeded, "the deadline has been exceeded before sending the request")
+		}
+		req.Header.Set("grpc-timeout", encodeTimeout(timeout))
+	}
 
-	res, err := t.client.Do(req)
+	res, err := t.do(ctx, req)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to send the API")
+		return nil, wrapError(err, "failed to send the API")
 	}
+	for redirects := 0; isRedirect(res.StatusCode); redirects++ {
+		// the body must be read to EOF to reuse the connection.
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxErrorBodySnippet))
+		res.Body.Close()
 
+		loc, err := res.Location()
+		if err != nil || !t.followRedirects || redirects >= maxRedirects || req.GetBody == nil {
+			rerr := &RedirectError{StatusCode: res.StatusCode}
+			if loc != nil {
+				rerr.Location = loc.String()
+			}
+			return nil, rerr
+		}
+		req, err = redirectRequest(req, loc)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build the redirected request")
+		}
+		res, err = t.do(ctx, req)
+		if err != nil {
+			return nil, wrapError(err, fmt.Sprintf("failed to send the API to %s", loc))
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		b, err := t.readErrorBody(res)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &HTTPStatusError{StatusCode: res.StatusCode, Body: b}
+	}
+
+	// misbehaving gateways may send duplicate content-types.
+	// keep only the grpc-web one so that subsequent header lookups see the right value.
+	if ct, ok := grpcWebContentType(res.Header); ok {
+		res.Header.Set("content-type", ct)
+	} else if ct := res.Header.Get("content-type"); isUnexpectedContentType(ct) {
+		// e.g. an error page of a proxy which doesn't forward requests to the gRPC Web filter.
+		b, err := t.readErrorBody(res)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ContentTypeError{ContentType: res.Header.Get("content-type"), Body: b}
+	}
+	t.header = headerToMetadata(res.Header)
+
+	if t.grpc {
+		return &grpcTrailerReader{res: res}, nil
+	}
+	if isTextContentType(res.Header.Get("content-type")) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{newTextReader(res.Body), res.Body}, nil
+	}
 	return res.Body, nil
 }
 
+// maxMappedErrorBody is the max length of the response body passed to the error mapper.
+const maxMappedErrorBody = 64 << 10
+
+// readErrorBody reads and closes the body of res which is not a gRPC Web response.
+// If the error mapper converts res into an error, it is returned.
+// Otherwise, the beginning of the body is returned for HTTPStatusError and ContentTypeError.
+func (t *HTTPTransport) readErrorBody(res *http.Response) ([]byte, error) {
+	defer res.Body.Close()
+	if t.errorMapper == nil {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySnippet))
+		return b, nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxMappedErrorBody))
+	if err := t.errorMapper(res, b); err != nil {
+		return nil, err
+	}
+	if len(b) > maxErrorBodySnippet {
+		b = b[:maxErrorBodySnippet]
+	}
+	return b, nil
+}
+
+// do sends req with ctx.
+func (t *HTTPTransport) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if t.responseHeaderTimeout > 0 {
+		return t.doWithHeaderTimeout(ctx, req)
+	}
+	return t.client.Do(req.WithContext(ctx))
+}
+
+// maxRedirects is the max number of redirects which HTTP transports follow. It is the same as net/http's.
+const maxRedirects = 10
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// sensitiveHeaders are not sent to other hosts on redirects, like net/http.
+var sensitiveHeaders = []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
+
+// redirectRequest builds a request which re-sends req to loc with the same method, body and headers.
+func redirectRequest(req *http.Request, loc *url.URL) (*http.Request, error) {
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(req.Method, loc.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	r.ContentLength = req.ContentLength
+	r.GetBody = req.GetBody
+	for k, vs := range req.Header {
+		r.Header[k] = append([]string(nil), vs...)
+	}
+	if loc.Hostname() == req.URL.Hostname() {
+		// e.g. from http to https on the same host.
+		r.Host = req.Host
+	} else {
+		for _, k := range sensitiveHeaders {
+			r.Header.Del(k)
+		}
+	}
+	return r, nil
+}
+
+// doWithHeaderTimeout sends req, and cancels it if the response headers don't arrive within the response header timeout.
+// The response body cancels the request when it is closed.
+func (t *HTTPTransport) doWithHeaderTimeout(parent context.Context, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(parent)
+	timer := startHeaderTimer(t.responseHeaderTimeout, cancel)
+	res, err := t.client.Do(req.WithContext(ctx))
+	if !timer.stop() && parent.Err() == nil {
+		// the timer has canceled the request before Do returned, so even a returned response has a canceled body.
+		if err == nil {
+			res.Body.Close()
+		}
+		return nil, status.Errorf(codes.DeadlineExceeded, "timeout awaiting response headers (%s)", t.responseHeaderTimeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// headerTimer cancels a request if it is not stopped within the timeout.
+// Either the timer or stop wins, so a response which has arrived in time is never canceled afterward.
+type headerTimer struct {
+	// state is 0 while waiting, 1 after the timer has canceled the request, and 2 after stop.
+	state  int32
+	cancel context.CancelFunc
+	timer  *time.Timer
+}
+
+func startHeaderTimer(d time.Duration, cancel context.CancelFunc) *headerTimer {
+	h := &headerTimer{cancel: cancel}
+	h.timer = time.AfterFunc(d, h.fire)
+	return h
+}
+
+func (h *headerTimer) fire() {
+	if atomic.CompareAndSwapInt32(&h.state, 0, 1) {
+		h.cancel()
+	}
+}
+
+// stop stops the timer, and reports whether it has stopped before the timer canceled the request.
+func (h *headerTimer) stop() bool {
+	h.timer.Stop()
+	return atomic.CompareAndSwapInt32(&h.state, 0, 2)
+}
+
+// cancelOnClose cancels the context of the request when the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// maxTimeoutValue is the max value of grpc-timeout header. The value must be at most 8 digits.
+const maxTimeoutValue int64 = 100000000 - 1
+
+// encodeTimeout encodes t as a value of grpc-timeout header in the finest unit which fits in 8 digits.
+// The value is rounded up, so a positive timeout is never encoded as 0.
+func encodeTimeout(t time.Duration) string {
+	units := []struct {
+		d    time.Duration
+		unit string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+	}
+	for _, u := range units {
+		if v := divCeil(t, u.d); v <= maxTimeoutValue {
+			return strconv.FormatInt(v, 10) + u.unit
+		}
+	}
+	return strconv.FormatInt(divCeil(t, time.Hour), 10) + "H"
+}
+
+func divCeil(d, r time.Duration) int64 {
+	if d%r > 0 {
+		return int64(d/r + 1)
+	}
+	return int64(d / r)
+}
+
+func (t *HTTPTransport) Header() metadata.MD {
+	return t.header
+}
+
+// headerToMetadata converts HTTP headers to metadata which has lowercased keys.
+func headerToMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, vs := range h {
+		md.Append(k, vs...)
+	}
+	return md
+}
+
+// grpcWebContentType finds the grpc-web content-type from all content-type values of h.
+// Each value may also be a comma-separated list.
+func grpcWebContentType(h http.Header) (string, bool) {
+	for _, v := range h[http.CanonicalHeaderKey("content-type")] {
+		for _, ct := range strings.Split(v, ",") {
+			ct = strings.TrimSpace(ct)
+			if strings.HasPrefix(strings.ToLower(ct), "application/grpc-web") {
+				return ct, true
+			}
+		}
+	}
+	return "", false
+}
+
+// grpcTrailerReader reads the body of a gRPC response followed by a trailer frame built from HTTP trailers,
+// so that gRPC responses can be handled in the same way as gRPC Web ones.
+type grpcTrailerReader struct {
+	res     *http.Response
+	trailer io.Reader
+}
+
+func (r *grpcTrailerReader) Read(p []byte) (int, error) {
+	if r.trailer == nil {
+		n, err := r.res.Body.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		// HTTP trailers are available after the body is read to EOF.
+		r.trailer = bytes.NewReader(grpcTrailerFrame(r.res))
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return r.trailer.Read(p)
+}
+
+func (r *grpcTrailerReader) Close() error {
+	return r.res.Body.Close()
+}
+
+// grpcTrailerFrame encodes HTTP trailers of res into a trailer frame.
+// In trailers-only responses, the status is sent in the headers instead of the trailers.
+func grpcTrailerFrame(res *http.Response) []byte {
+	trailer := res.Trailer
+	if trailer.Get("grpc-status") == "" && res.Header.Get("grpc-status") != "" {
+		trailer = http.Header{}
+		for k, vs := range res.Header {
+			if strings.HasPrefix(strings.ToLower(k), "grpc-") {
+				trailer[k] = vs
+			}
+		}
+	}
+
+	return trailerFrame(metadata.MD(trailer))
+}
+
+// HTTPTransportBuilder builds HTTPTransport which speaks plaintext HTTP with the default settings.
 func HTTPTransportBuilder(host string, req *Request) Transport {
+	return HTTPTransportBuilderWithOptions(host, req, &TransportOptions{Insecure: true})
+}
+
+// HTTPTransportBuilderWithOptions builds HTTPTransport configured by opts.
+func HTTPTransportBuilderWithOptions(host string, req *Request, opts *TransportOptions) Transport {
+	client := opts.HTTPClient
+	if client == nil {
+		client = newHTTPClient(opts)
+	}
 	return &HTTPTransport{
-		host:   host,
-		req:    req,
-		client: &http.Client{},
+		host:        host,
+		authority:   opts.Authority,
+		path:        opts.path(req.endpoint),
+		req:         req,
+		client:      client,
+		insecure:    opts.Insecure,
+		contentType: opts.contentType(),
+		xGRPCWeb:    opts.xGRPCWeb(),
+		userAgent:   opts.userAgent(),
+		compression: opts.Compression,
+		grpc:        opts.isGRPC(),
+		text:        isTextContentType(opts.contentType()),
+
+		acceptEncoding: opts.acceptEncoding(),
+		errorMapper:    opts.ErrorMapper,
+
+		disableTETrailers:     opts.DisableTETrailers,
+		followRedirects:       opts.FollowRedirects,
+		responseHeaderTimeout: opts.ResponseHeaderTimeout,
 	}
 }
 
 // StreamTransport is used to send API requests for ClientStreamClient and BidiStreamClient.
 type StreamTransport interface {
+	// Send sends body as a message. body must not be retained after Send returns, because it is reused.
 	Send(body io.Reader) error
 	Receive() (io.ReadCloser, error)
 
-	// Finish sends EOF request to the server.
+	// Finish sends EOF request to the server, and returns the remaining responses.
 	Finish() (io.ReadCloser, error)
 
 	// Close closes the connection.
 	Close() error
 }
 
+// StreamTransportCloseSender is implemented by StreamTransports which can half-close the stream.
+// It is separated from StreamTransport so that existing implementations of StreamTransport keep compiling.
+type StreamTransportCloseSender interface {
+	// CloseSend sends EOF request to the server, but keeps receiving responses. (half-close)
+	// Send returns ErrSendAfterCloseSend after CloseSend.
+	CloseSend() error
+}
+
+// closeSend half-closes t if it implements StreamTransportCloseSender.
+func closeSend(t StreamTransport) error {
+	cs, ok := t.(StreamTransportCloseSender)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "the stream transport %T doesn't support CloseSend", t)
+	}
+	return cs.CloseSend()
+}
+
+// StreamTransportPinger is implemented by StreamTransports which can check the liveness of the connection.
+// It is separated from StreamTransport so that existing implementations of StreamTransport keep compiling.
+type StreamTransportPinger interface {
+	// Ping checks that the connection is alive, by waiting for the response of a ping until ctx is done.
+	Ping(ctx context.Context) error
+}
+
+// ping pings t if it implements StreamTransportPinger.
+func ping(ctx context.Context, t StreamTransport) error {
+	p, ok := t.(StreamTransportPinger)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "the stream transport %T doesn't support Ping", t)
+	}
+	return p.Ping(ctx)
+}
+
 // WebSocketTransport is a stream transport implementation.
 //
 // Currently, gRPC Web specification does not support client streaming. (https://github.com/improbable-eng/grpc-web#client-side-streaming)
@@ -99,6 +853,7 @@ type StreamTransport interface {
 //
 // spec: https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
 type WebSocketTransport struct {
+	ctx  context.Context
 	conn *websocket.Conn
 
 	once    sync.Once
@@ -106,24 +861,56 @@ type WebSocketTransport struct {
 
 	m      sync.Mutex
 	closed bool
+
+	// gorilla/websocket supports one concurrent reader and one concurrent writer.
+	// rm guards reading from conn and wm guards writing to conn.
+	rm sync.Mutex
+	wm sync.Mutex
+	// sendClosed is true after the EOF request is sent. It is guarded by wm.
+	sendClosed bool
+
+	contentType  string
+	xGRPCWeb     string
+	compression  string
+	drainTimeout time.Duration
+	sendTimeout  time.Duration
+	// acceptEncoding is the value of grpc-accept-encoding header.
+	acceptEncoding string
+	// maxMessageSize is the max size of each message sent by Send. Zero means no limit.
+	maxMessageSize int
+	// header is request metadata attached to the context, which is sent with the headers of the stream.
+	header metadata.MD
+
+	// done is closed by closeConn to stop keepalive and watching the context.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// pongc is closed and replaced when a pong message arrives.
+	pm    sync.Mutex
+	pongc chan struct{}
+
+	// pumping is 1 while Ping or keepalive reads from conn to handle the pong message.
+	pumping int32
+	// pending is data messages which Ping has read, and Receive hasn't returned yet. It is guarded by rm.
+	pending []pendingMessage
 }
 
-func (t *WebSocketTransport) Send(body io.Reader) error {
+// pendingMessage is the result of a read by Ping.
+type pendingMessage struct {
+	b   []byte
+	err error
+}
+
+func (t *WebSocketTransport) isClosed() bool {
 	t.m.Lock()
-	if t.closed {
+	defer t.m.Unlock()
+	return t.closed
+}
+
+func (t *WebSocketTransport) Send(body io.Reader) error {
+	if t.isClosed() {
 		return ErrConnectionClosed
 	}
-	t.m.Unlock()
-
-	t.once.Do(func() {
-		h := http.Header{}
-		h.Set("content-type", "application/grpc-web+proto")
-		h.Set("x-grpc-web", "1")
-		var b bytes.Buffer
-		h.Write(&b)
-
-		t.conn.WriteMessage(websocket.BinaryMessage, b.Bytes())
-	})
 
 	var b bytes.Buffer
 	b.Write([]byte{0x00})
@@ -132,35 +919,141 @@ func (t *WebSocketTransport) Send(body io.Reader) error {
 		return errors.Wrap(err, "failed to read request body")
 	}
 
-	return t.conn.WriteMessage(websocket.BinaryMessage, b.Bytes())
+	t.wm.Lock()
+	defer t.wm.Unlock()
+
+	// sendClosed must be checked while holding wm, otherwise CloseSend may send the EOF request before the message.
+	if t.sendClosed {
+		return ErrSendAfterCloseSend
+	}
+
+	if t.sendTimeout > 0 {
+		t.conn.SetWriteDeadline(time.Now().Add(t.sendTimeout))
+		defer t.conn.SetWriteDeadline(time.Time{})
+	}
+
+	t.once.Do(t.writeHeader)
+
+	for _, msg := range splitMessage(b.Bytes(), t.maxMessageSize) {
+		if err := t.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			if cerr := t.ctx.Err(); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMessage splits a data message which has the leading byte 0x00 into messages of at most size bytes.
+// Each message has the leading byte, and the server concatenates the rest of them,
+// so the frames in the request are reassembled regardless of the message boundaries.
+func splitMessage(b []byte, size int) [][]byte {
+	if size <= 0 || len(b) <= size {
+		return [][]byte{b}
+	}
+	if size < 2 {
+		size = 2
+	}
+
+	data := b[1:]
+	msgs := make([][]byte, 0, (len(data)+size-2)/(size-1))
+	for len(data) > 0 {
+		n := size - 1
+		if n > len(data) {
+			n = len(data)
+		}
+		msg := make([]byte, n+1)
+		msg[0] = 0x00
+		copy(msg[1:], data[:n])
+		msgs = append(msgs, msg)
+		data = data[n:]
+	}
+	return msgs
+}
+
+// writeHeader writes the request headers as the first message. The caller must hold wm.
+func (t *WebSocketTransport) writeHeader() {
+	h := http.Header{}
+	h.Set("content-type", t.contentType)
+	if t.xGRPCWeb != "" {
+		h.Set("x-grpc-web", t.xGRPCWeb)
+	}
+	if t.compression != "" {
+		h.Set("grpc-encoding", t.compression)
+	}
+	if t.acceptEncoding != "" {
+		h.Set("grpc-accept-encoding", t.acceptEncoding)
+	}
+	for k, vs := range t.header {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	var b bytes.Buffer
+	h.Write(&b)
+
+	t.conn.WriteMessage(websocket.BinaryMessage, b.Bytes())
+}
+
+// CloseSend sends the EOF request without closing the connection, so that the remaining responses can be received.
+// It is no-op if the EOF request has already been sent.
+func (t *WebSocketTransport) CloseSend() error {
+	if t.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	t.wm.Lock()
+	defer t.wm.Unlock()
+
+	if t.sendClosed {
+		return nil
+	}
+	t.sendClosed = true
+
+	// the server expects the headers before the EOF request even if no messages are sent.
+	t.once.Do(t.writeHeader)
+	return t.conn.WriteMessage(websocket.BinaryMessage, []byte{0x01})
 }
 
 func (t *WebSocketTransport) Receive() (res io.ReadCloser, err error) {
-	t.m.Lock()
-	if t.closed {
+	if t.isClosed() {
 		return nil, ErrConnectionClosed
 	}
-	t.m.Unlock()
+
+	t.rm.Lock()
+	defer t.rm.Unlock()
 
 	defer func() {
 		if err == nil {
 			return
 		}
 
-		if berr, ok := errors.Cause(err).(*net.OpError); ok && !berr.Temporary() {
-			err = ErrConnectionClosed
+		// the connection is closed when the context is done.
+		if cerr := t.ctx.Err(); cerr != nil {
+			err = cerr
+			return
+		}
+
+		switch cause := errors.Cause(err).(type) {
+		case *websocket.CloseError:
+			err = closeError(cause)
+		case *net.OpError:
+			if !cause.Temporary() {
+				err = ErrConnectionClosed
+			}
 		}
 	}()
 
 	// skip response header
 	t.resOnce.Do(func() {
-		_, _, err = t.conn.ReadMessage()
+		_, err = t.readMessage()
 		if err != nil {
 			err = errors.Wrap(err, "failed to read response header")
 			return
 		}
 
-		_, _, err = t.conn.ReadMessage()
+		_, err = t.readMessage()
 		if err != nil {
 			err = errors.Wrap(err, "failed to read response header")
 			return
@@ -170,58 +1063,338 @@ func (t *WebSocketTransport) Receive() (res io.ReadCloser, err error) {
 	var buf bytes.Buffer
 	var b []byte
 
-	_, b, err = t.conn.ReadMessage()
+	b, err = t.readMessage()
 	if err != nil {
 		err = errors.Wrap(err, "failed to read response body")
 		return
 	}
 	buf.Write(b)
 
-	var r io.Reader
-	_, r, err = t.conn.NextReader()
+	// read the whole body while holding rm because the next read invalidates the reader.
+	b, err = t.readMessage()
 	if err != nil {
+		err = errors.Wrap(err, "failed to read response body")
 		return
 	}
+	buf.Write(b)
 
-	res = ioutil.NopCloser(io.MultiReader(&buf, r))
+	res = ioutil.NopCloser(&buf)
 
 	return
 }
 
+// readMessage reads the next data message, which may have been read by Ping. The caller must hold rm.
+func (t *WebSocketTransport) readMessage() ([]byte, error) {
+	if len(t.pending) > 0 {
+		m := t.pending[0]
+		t.pending = t.pending[1:]
+		return m.b, m.err
+	}
+	_, b, err := t.conn.ReadMessage()
+	return b, err
+}
+
+// Ping sends a ping message, and waits for the pong message until ctx is done.
+// A data message which arrives before the pong also proves that the connection is alive,
+// and it is kept for the next Receive.
+func (t *WebSocketTransport) Ping(ctx context.Context) error {
+	if t.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	pong := t.pongSignal()
+	deadline, _ := ctx.Deadline()
+	if err := t.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+		return errors.Wrap(err, "failed to send a ping message")
+	}
+
+	select {
+	case <-pong:
+		return nil
+	case err := <-t.pump():
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return ErrConnectionClosed
+	}
+}
+
+// pump reads a message from conn, so that the pong message is handled even if Receive is not reading.
+// The read message is kept for the next Receive. The returned channel receives the error of the read.
+// It returns nil if another Ping or keepalive is already reading.
+func (t *WebSocketTransport) pump() <-chan error {
+	if !atomic.CompareAndSwapInt32(&t.pumping, 0, 1) {
+		return nil
+	}
+	errc := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&t.pumping, 0)
+
+		// if Receive is reading, the pong is handled by it, and this read waits for the next message.
+		t.rm.Lock()
+		defer t.rm.Unlock()
+
+		_, b, err := t.conn.ReadMessage()
+		t.pending = append(t.pending, pendingMessage{b: b, err: err})
+		errc <- err
+	}()
+	return errc
+}
+
+// pongSignal returns a channel which is closed when the next pong message arrives.
+func (t *WebSocketTransport) pongSignal() <-chan struct{} {
+	t.pm.Lock()
+	defer t.pm.Unlock()
+	return t.pongc
+}
+
+func (t *WebSocketTransport) handlePong(string) error {
+	t.pm.Lock()
+	defer t.pm.Unlock()
+	close(t.pongc)
+	t.pongc = make(chan struct{})
+	return nil
+}
+
 func (t *WebSocketTransport) Finish() (io.ReadCloser, error) {
-	defer t.conn.Close()
+	if err := t.CloseSend(); err != nil {
+		// return the write error as the root cause, rather than the error of closing.
+		t.closeConn()
+		return nil, errors.Wrap(err, "failed to send the EOF request")
+	}
+	defer t.closeConn()
 
-	t.conn.WriteMessage(websocket.BinaryMessage, []byte{0x01})
+	// read frames until the trailer frame.
+	// the server may close the connection promptly after sending the trailer,
+	// so the trailer must be read before handling the close.
+	var buf bytes.Buffer
+	for {
+		res, err := t.Receive()
+		if err != nil {
+			if buf.Len() != 0 && isConnectionClosed(err) {
+				break
+			}
+			return nil, err
+		}
 
-	res, err := t.Receive()
-	if err != nil {
-		return nil, err
+		n := buf.Len()
+		if _, err := io.Copy(&buf, res); err != nil {
+			return nil, errors.Wrap(err, "failed to read response body")
+		}
+		if buf.Len() > n && buf.Bytes()[n]&flagTrailer != 0 {
+			break
+		}
 	}
 
-	err = t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-	if err != nil {
-		return nil, err
+	// the server may have already closed the connection.
+	t.writeMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (t *WebSocketTransport) writeMessage(messageType int, data []byte) error {
+	t.wm.Lock()
+	defer t.wm.Unlock()
+	return t.conn.WriteMessage(messageType, data)
+}
+
+// isConnectionClosed reports whether err is caused by closing the connection.
+func isConnectionClosed(err error) bool {
+	cause := errors.Cause(err)
+	if _, ok := cause.(*websocket.CloseError); ok {
+		return true
+	}
+	if status.Code(cause) == codes.Unavailable {
+		return true
 	}
+	return cause == ErrConnectionClosed || cause == io.EOF || cause == io.ErrUnexpectedEOF
+}
 
-	return ioutil.NopCloser(res), nil
+// closeError translates a close frame sent by the server.
+// A normal closure ends the stream with io.EOF, and others result in an Unavailable error which has the close code and reason.
+func closeError(err *websocket.CloseError) error {
+	switch err.Code {
+	case websocket.CloseNormalClosure, websocket.CloseNoStatusReceived:
+		return io.EOF
+	}
+	return status.Errorf(codes.Unavailable, "the stream is closed by the server (close code %d): %s", err.Code, err.Text)
 }
 
 func (t *WebSocketTransport) Close() error {
 	t.m.Lock()
-	defer t.m.Unlock()
 	t.closed = true
+	t.m.Unlock()
+
+	// stop keepalive before draining, so that it doesn't close the connection during the drain.
+	t.stop()
+
+	if t.drainTimeout > 0 {
+		t.drain()
+	}
+
+	return t.closeConn()
+}
+
+// stop closes done to stop keepalive and watching the context.
+func (t *WebSocketTransport) stop() {
+	t.doneOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// closeConn closes the connection. Every path which closes the connection must use it,
+// otherwise the goroutine watching the context leaks until the context is done.
+func (t *WebSocketTransport) closeConn() error {
+	t.stop()
 	return t.conn.Close()
 }
 
+// keepalive sends a ping message at every interval, and closes the connection if the pong doesn't arrive within timeout.
+// Closing the connection makes Send and Receive fail.
+// Like Ping, it reads from conn while Receive is idle, so that the pong is handled and a dead connection is detected.
+func (t *WebSocketTransport) keepalive(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+
+		pong := t.pongSignal()
+		if err := t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+			t.closeConn()
+			return
+		}
+
+		select {
+		case <-t.done:
+			return
+		case <-pong:
+		case err := <-t.pump():
+			// a data message also proves that the connection is alive.
+			if err != nil {
+				t.closeConn()
+				return
+			}
+		case <-time.After(timeout):
+			t.closeConn()
+			return
+		}
+	}
+}
+
+// drain sends a close message and discards remaining frames until the server closes the connection.
+// It gives up draining after the drain timeout.
+func (t *WebSocketTransport) drain() {
+	deadline := time.Now().Add(t.drainTimeout)
+	err := t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+	if err != nil {
+		return
+	}
+
+	// the deadline also interrupts an in-flight Receive.
+	t.conn.SetReadDeadline(deadline)
+
+	t.rm.Lock()
+	defer t.rm.Unlock()
+	for {
+		if _, _, err := t.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// WebSocketTransportBuilder opens a plaintext WebSocket connection with the default settings.
 func WebSocketTransportBuilder(host string, endpoint string) (StreamTransport, error) {
-	u := url.URL{Scheme: "ws", Host: host, Path: endpoint}
+	return WebSocketTransportBuilderWithOptions(context.Background(), host, endpoint, &TransportOptions{Insecure: true})
+}
+
+// WebSocketTransportBuilderWithOptions opens a WebSocket connection configured by opts.
+// The connection is closed when ctx is done, then Send and Receive return ctx.Err().
+func WebSocketTransportBuilderWithOptions(ctx context.Context, host string, endpoint string, opts *TransportOptions) (StreamTransport, error) {
+	// done stops watching ctx. It is closed when the connection is closed, or if dialing fails.
+	done := make(chan struct{})
+	var netDialer net.Dialer
+
+	scheme := "wss"
+	dialer := &websocket.Dialer{
+		// gorilla/websocket doesn't accept a context, so the connection is closed when ctx is done instead.
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := netDialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			}()
+			return conn, nil
+		},
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: opts.DialTimeout,
+	}
+	if opts.Insecure {
+		scheme = "ws"
+	} else {
+		dialer.TLSClientConfig = opts.tlsConfig()
+	}
+
+	u := url.URL{Scheme: scheme, Host: host, Path: opts.path(endpoint)}
 	h := http.Header{}
-	h.Set("Sec-WebSocket-Protocol", "grpc-websockets")
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), h)
+	for k, vs := range opts.WebSocketHeader {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	// request metadata is also sent in the handshake, so that gateways can authenticate the connection.
+	md := HeaderFromContext(ctx)
+	for k, vs := range md {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	if opts.Authority != "" {
+		// gorilla/websocket sends the Host header as the host of the request.
+		h.Set("Host", opts.Authority)
+	}
+	h.Set("Sec-WebSocket-Protocol", opts.webSocketSubprotocol())
+	h.Set("User-Agent", opts.userAgent())
+	conn, _, err := dialer.Dial(u.String(), h)
 	if err != nil {
+		close(done)
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
 		return nil, err
 	}
-	return &WebSocketTransport{
-		conn: conn,
-	}, nil
+	t := &WebSocketTransport{
+		ctx:          ctx,
+		conn:         conn,
+		contentType:  opts.contentType(),
+		xGRPCWeb:     opts.xGRPCWeb(),
+		compression:  opts.Compression,
+		drainTimeout: opts.DrainTimeout,
+		sendTimeout:  opts.SendTimeout,
+		done:         done,
+		pongc:        make(chan struct{}),
+
+		acceptEncoding: opts.acceptEncoding(),
+		maxMessageSize: opts.WebSocketMaxMessageSize,
+		header:         md,
+	}
+	conn.SetPongHandler(t.handlePong)
+	if opts.KeepaliveInterval > 0 {
+		timeout := opts.KeepaliveTimeout
+		if timeout <= 0 {
+			timeout = opts.KeepaliveInterval
+		}
+		go t.keepalive(opts.KeepaliveInterval, timeout)
+	}
+	return t, nil
 }
@@ -0,0 +1,1021 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/gorilla/websocket"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCWebContentType(t *testing.T) {
+	cases := map[string]struct {
+		values   []string
+		expected string
+		ok       bool
+	}{
+		"single":                  {values: []string{"application/grpc-web+proto"}, expected: "application/grpc-web+proto", ok: true},
+		"duplicate":               {values: []string{"text/plain", "application/grpc-web+proto"}, expected: "application/grpc-web+proto", ok: true},
+		"comma-separated":         {values: []string{"text/html, application/grpc-web"}, expected: "application/grpc-web", ok: true},
+		"no grpc-web":             {values: []string{"text/html"}},
+		"no content-type headers": {},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			h := http.Header{}
+			for _, v := range c.values {
+				h.Add("content-type", v)
+			}
+			ct, ok := grpcWebContentType(h)
+			assert.Equal(t, c.ok, ok)
+			assert.Equal(t, c.expected, ct)
+		})
+	}
+}
+
+// newWebSocketServer starts a WebSocket server which handles connections by h.
+func newWebSocketServer(t *testing.T, h func(*websocket.Conn)) *httptest.Server {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"grpc-websockets"},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		h(conn)
+	}))
+}
+
+func TestWebSocketTransportClose(t *testing.T) {
+	// the server keeps sending frames and never replies to the close message.
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		for {
+			if err := conn.WriteMessage(websocket.BinaryMessage, []byte{0x00}); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("drain timeout bounds Close", func(t *testing.T) {
+		tr, err := WebSocketTransportBuilderWithOptions(context.Background(), host, "/api.Example/BidiStreaming", &TransportOptions{
+			Insecure:     true,
+			DrainTimeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		start := time.Now()
+		assert.NoError(t, tr.Close())
+		elapsed := time.Since(start)
+		assert.True(t, elapsed >= 100*time.Millisecond, "Close must drain frames until the drain timeout, but returned in %s", elapsed)
+		assert.True(t, elapsed < time.Second, "Close must not block longer than the drain timeout, but took %s", elapsed)
+	})
+
+	t.Run("no drain timeout", func(t *testing.T) {
+		tr, err := WebSocketTransportBuilderWithOptions(context.Background(), host, "/api.Example/BidiStreaming", &TransportOptions{
+			Insecure: true,
+		})
+		require.NoError(t, err)
+
+		start := time.Now()
+		assert.NoError(t, tr.Close())
+		assert.True(t, time.Since(start) < 100*time.Millisecond)
+	})
+}
+
+func TestWebSocketTransportMaxMessageSize(t *testing.T) {
+	type received struct {
+		sizes []int
+		body  []byte
+	}
+	recv := make(chan received, 1)
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		// skip the request headers.
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Error(err)
+			return
+		}
+		var r received
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if b[0] == 0x01 {
+				break
+			}
+			// the same as grpc-websockets servers, the leading byte is dropped and the rest is concatenated.
+			r.sizes = append(r.sizes, len(b))
+			r.body = append(r.body, b[1:]...)
+		}
+		recv <- r
+	})
+	defer srv.Close()
+
+	tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/ClientStreaming", &TransportOptions{
+		Insecure:                true,
+		WebSocketMaxMessageSize: 8,
+	})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	frames := [][]byte{frame(0x00, []byte("large message")), frame(0x00, []byte("foo")), frame(0x00, nil)}
+	for _, f := range frames {
+		require.NoError(t, tr.Send(bytes.NewReader(f)))
+	}
+	require.NoError(t, tr.(StreamTransportCloseSender).CloseSend())
+
+	r := <-recv
+	assert.Equal(t, []int{8, 8, 5, 8, 2, 6}, r.sizes)
+
+	fr := NewFrameReader(bytes.NewReader(r.body))
+	for _, expected := range []string{"large message", "foo", ""} {
+		_, payload, err := fr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(payload))
+	}
+	_, _, err = fr.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWebSocketTransportSendTimeout(t *testing.T) {
+	done := make(chan struct{})
+	// the server never reads messages, so the send buffer gets full.
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		<-done
+	})
+	defer srv.Close()
+	defer close(done)
+
+	tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/ClientStreaming", &TransportOptions{
+		Insecure:    true,
+		SendTimeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	body := make([]byte, 1<<20)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			if err := tr.Send(bytes.NewReader(body)); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Send was not timed out")
+	}
+}
+
+func TestWebSocketTransportFinish(t *testing.T) {
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		conn.ReadMessage()
+	})
+	defer srv.Close()
+
+	tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/ClientStreaming", &TransportOptions{
+		Insecure: true,
+	})
+	require.NoError(t, err)
+
+	// break the connection to fail sending the EOF request.
+	tr.(*WebSocketTransport).conn.UnderlyingConn().Close()
+
+	_, err = tr.Finish()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to send the EOF request")
+}
+
+func TestWebSocketTransportFinishStopsWatchingContext(t *testing.T) {
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		// the headers and the EOF request.
+		for i := 0; i < 2; i++ {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+		trailer := TrailerFrame(codes.OK, "")
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		conn.WriteMessage(websocket.BinaryMessage, []byte("grpc-status: 0\r\n"))
+		conn.WriteMessage(websocket.BinaryMessage, trailer[:headerLen])
+		conn.WriteMessage(websocket.BinaryMessage, trailer[headerLen:])
+		conn.ReadMessage()
+	})
+	defer srv.Close()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		// the legacy builder watches context.Background, which is never done.
+		tr, err := WebSocketTransportBuilder(strings.TrimPrefix(srv.URL, "http://"), "/api.Example/ClientStreaming")
+		require.NoError(t, err)
+		_, err = tr.Finish()
+		require.NoError(t, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= before, "goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+}
+
+func TestWebSocketTransportCloseSend(t *testing.T) {
+	for name, messages := range map[string]int{"after Send": 1, "without Send": 0} {
+		messages := messages
+		t.Run(name, func(t *testing.T) {
+			srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+				if _, b, err := conn.ReadMessage(); err != nil || !strings.Contains(strings.ToLower(string(b)), "content-type") {
+					t.Errorf("the first message must be the request header, but %q (%v)", b, err)
+					return
+				}
+				for i := 0; i < messages; i++ {
+					conn.ReadMessage()
+				}
+				if _, b, err := conn.ReadMessage(); err != nil || !bytes.Equal(b, []byte{0x01}) {
+					t.Errorf("expected the EOF request, but %q (%v)", b, err)
+					return
+				}
+				// respond after the half-close.
+				conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+				conn.WriteMessage(websocket.BinaryMessage, []byte("\r\n"))
+				for _, f := range [][]byte{frame(0x00, []byte("foo")), TrailerFrame(codes.OK, "")} {
+					conn.WriteMessage(websocket.BinaryMessage, f[:headerLen])
+					conn.WriteMessage(websocket.BinaryMessage, f[headerLen:])
+				}
+				conn.ReadMessage()
+			})
+			defer srv.Close()
+
+			tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+				Insecure: true,
+			})
+			require.NoError(t, err)
+			defer tr.Close()
+
+			for i := 0; i < messages; i++ {
+				require.NoError(t, tr.Send(bytes.NewReader(frame(0x00, nil))))
+			}
+			require.NoError(t, tr.(StreamTransportCloseSender).CloseSend())
+			require.NoError(t, tr.(StreamTransportCloseSender).CloseSend(), "CloseSend must be idempotent")
+			assert.Equal(t, ErrSendAfterCloseSend, tr.Send(bytes.NewReader(frame(0x00, nil))), "Send must fail after CloseSend")
+
+			for _, flag := range []byte{0x00, flagTrailer} {
+				res, err := tr.Receive()
+				require.NoError(t, err)
+				f, _, err := readFrame(res)
+				require.NoError(t, err)
+				assert.Equal(t, flag, f)
+			}
+		})
+	}
+}
+
+func TestWebSocketTransportSendRacesCloseSend(t *testing.T) {
+	// the server fails if a data message arrives after the EOF request.
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		conn.ReadMessage() // headers
+		var eof bool
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if eof {
+				t.Errorf("a message %q is sent after the EOF request", b)
+			}
+			eof = eof || bytes.Equal(b, []byte{0x01})
+		}
+	})
+	defer srv.Close()
+
+	for i := 0; i < 20; i++ {
+		tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+			Insecure: true,
+		})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := tr.Send(bytes.NewReader(frame(0x00, nil))); err != nil {
+					assert.Equal(t, ErrSendAfterCloseSend, err)
+					return
+				}
+			}
+		}()
+		require.NoError(t, tr.(StreamTransportCloseSender).CloseSend())
+		wg.Wait()
+		tr.Close()
+	}
+}
+
+func TestWebSocketTransportPing(t *testing.T) {
+	dial := func(t *testing.T, srv *httptest.Server) StreamTransport {
+		tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+			Insecure: true,
+		})
+		require.NoError(t, err)
+		return tr
+	}
+
+	t.Run("pong", func(t *testing.T) {
+		// the server responds to pings while reading.
+		srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+			conn.ReadMessage()
+		})
+		defer srv.Close()
+		tr := dial(t, srv)
+		defer tr.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t, tr.(StreamTransportPinger).Ping(ctx))
+	})
+
+	t.Run("no pong", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+			<-release
+		})
+		defer srv.Close()
+		defer close(release)
+		tr := dial(t, srv)
+		defer tr.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, tr.(StreamTransportPinger).Ping(ctx))
+	})
+
+	t.Run("messages read by Ping are received", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+			// the server sends responses without responding to pings.
+			conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+			conn.WriteMessage(websocket.BinaryMessage, []byte("\r\n"))
+			f := frame(0x00, []byte("foo"))
+			conn.WriteMessage(websocket.BinaryMessage, f[:headerLen])
+			conn.WriteMessage(websocket.BinaryMessage, f[headerLen:])
+			<-release
+		})
+		defer srv.Close()
+		defer close(release)
+		tr := dial(t, srv)
+		defer tr.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t, tr.(StreamTransportPinger).Ping(ctx))
+
+		res, err := tr.Receive()
+		require.NoError(t, err)
+		_, b, err := readFrame(res)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", string(b))
+	})
+}
+
+func TestWebSocketTransportXGRPCWeb(t *testing.T) {
+	cases := map[string]struct {
+		opts     TransportOptions
+		expected string
+	}{
+		"default":  {expected: "x-grpc-web: 1"},
+		"disabled": {opts: TransportOptions{DisableXGRPCWeb: true}},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			header := make(chan string, 1)
+			srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+				_, b, _ := conn.ReadMessage()
+				header <- string(b)
+			})
+			defer srv.Close()
+
+			c.opts.Insecure = true
+			tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/ClientStreaming", &c.opts)
+			require.NoError(t, err)
+			defer tr.Close()
+			require.NoError(t, tr.Send(bytes.NewReader(frame(0x00, nil))))
+
+			h := strings.ToLower(<-header)
+			if c.expected == "" {
+				assert.NotContains(t, h, "x-grpc-web")
+			} else {
+				assert.Contains(t, h, c.expected)
+			}
+		})
+	}
+}
+
+func TestWebSocketTransportServerClose(t *testing.T) {
+	cases := map[string]struct {
+		code    int
+		text    string
+		errCode codes.Code
+	}{
+		"normal closure": {code: websocket.CloseNormalClosure, errCode: codes.OK},
+		"internal error": {code: websocket.CloseInternalServerErr, text: "boom", errCode: codes.Unavailable},
+		"going away":     {code: websocket.CloseGoingAway, text: "restarting", errCode: codes.Unavailable},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+				for _, b := range [][]byte{[]byte("header"), []byte("\r\n")} {
+					conn.WriteMessage(websocket.BinaryMessage, b)
+				}
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.code, c.text))
+				conn.ReadMessage()
+			})
+			defer srv.Close()
+
+			tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+				Insecure: true,
+			})
+			require.NoError(t, err)
+			defer tr.Close()
+
+			_, err = tr.Receive()
+			if c.errCode == codes.OK {
+				assert.Equal(t, io.EOF, err)
+				return
+			}
+			assert.Equal(t, c.errCode, status.Code(err), "%v", err)
+			assert.Contains(t, status.Convert(err).Message(), c.text)
+		})
+	}
+}
+
+func TestWebSocketTransportConcurrentSendReceive(t *testing.T) {
+	const n = 20
+
+	srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+		// request header
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		// response header
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		conn.WriteMessage(websocket.BinaryMessage, []byte("grpc-status: 0\r\n"))
+
+		// echo each request message
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil || len(b) == 0 || b[0] != 0x00 {
+				return
+			}
+			conn.WriteMessage(websocket.BinaryMessage, b[1:headerLen+1])
+			conn.WriteMessage(websocket.BinaryMessage, b[headerLen+1:])
+		}
+	})
+	defer srv.Close()
+
+	tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+		Insecure: true,
+	})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := i; j < n; j += 2 {
+				assert.NoError(t, tr.Send(bytes.NewReader(frame(0x00, []byte{byte(j)}))))
+			}
+		}(i)
+	}
+
+	received := make(chan byte, n)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n/2; j++ {
+				res, err := tr.Receive()
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, b, err := readFrame(res)
+				if assert.NoError(t, err) && assert.Len(t, b, 1) {
+					received <- b[0]
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(received)
+
+	seen := make(map[byte]bool)
+	for b := range received {
+		seen[b] = true
+	}
+	assert.Len(t, seen, n)
+}
+
+func TestWebSocketTransportDialTimeout(t *testing.T) {
+	// the listener accepts connections, but never responds to the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := WebSocketTransportBuilderWithOptions(context.Background(), l.Addr().String(), "/api.Example/ClientStreaming", &TransportOptions{
+			Insecure:    true,
+			DialTimeout: 100 * time.Millisecond,
+		})
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("dialing was not timed out")
+	}
+}
+
+func TestWebSocketTransportContext(t *testing.T) {
+	t.Run("dialing is aborted", func(t *testing.T) {
+		// the listener accepts connections, but never responds to the handshake.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		errc := make(chan error, 1)
+		go func() {
+			_, err := WebSocketTransportBuilderWithOptions(ctx, l.Addr().String(), "/api.Example/BidiStreaming", &TransportOptions{
+				Insecure: true,
+			})
+			errc <- err
+		}()
+
+		select {
+		case err := <-errc:
+			assert.Equal(t, context.DeadlineExceeded, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("dialing was not aborted")
+		}
+	})
+
+	t.Run("Receive is aborted", func(t *testing.T) {
+		// the server never responds.
+		srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		})
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tr, err := WebSocketTransportBuilderWithOptions(ctx, strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+			Insecure: true,
+		})
+		require.NoError(t, err)
+		defer tr.Close()
+
+		errc := make(chan error, 1)
+		go func() {
+			_, err := tr.Receive()
+			errc <- err
+		}()
+		cancel()
+
+		select {
+		case err := <-errc:
+			assert.Equal(t, context.Canceled, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Receive was not aborted")
+		}
+		assert.Equal(t, context.Canceled, tr.Send(bytes.NewReader(frame(0x00, nil))))
+	})
+}
+
+func TestWebSocketTransportHandshake(t *testing.T) {
+	cases := map[string]struct {
+		opts                                            TransportOptions
+		subprotocol, origin, userAgent, path, authority string
+	}{
+		"default": {subprotocol: "grpc-websockets", userAgent: "grpc-web-go-client/" + Version, path: "/api.Example/ClientStreaming"},
+		"custom": {
+			opts:        TransportOptions{WebSocketSubprotocol: "grpc-ws", WebSocketHeader: http.Header{"Origin": {"https://example.com"}}, UserAgent: "foo/1.0", BasePath: "/grpc", Authority: "api.example.com"},
+			subprotocol: "grpc-ws",
+			origin:      "https://example.com",
+			userAgent:   "foo/1.0",
+			path:        "/grpc/api.Example/ClientStreaming",
+			authority:   "api.example.com",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			var (
+				header     http.Header
+				path, host string
+			)
+			upgrader := websocket.Upgrader{
+				Subprotocols: []string{c.subprotocol},
+				CheckOrigin:  func(*http.Request) bool { return true },
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header, path, host = r.Header, r.URL.Path, r.Host
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.Close()
+			}))
+			defer srv.Close()
+
+			c.opts.Insecure = true
+			tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/ClientStreaming", &c.opts)
+			require.NoError(t, err)
+			defer tr.Close()
+
+			assert.Equal(t, c.subprotocol, tr.(*WebSocketTransport).conn.Subprotocol())
+			assert.Equal(t, c.origin, header.Get("Origin"))
+			assert.Equal(t, c.userAgent, header.Get("User-Agent"))
+			assert.Equal(t, c.path, path)
+			if c.authority == "" {
+				assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), host)
+			} else {
+				assert.Equal(t, c.authority, host)
+			}
+		})
+	}
+}
+
+func TestEncodeTimeout(t *testing.T) {
+	cases := map[time.Duration]string{
+		1:                        "1n",
+		time.Millisecond:         "1000000n",
+		100 * time.Millisecond:   "100000u",
+		100*time.Millisecond + 1: "100001u",
+		time.Hour:                "3600000m",
+		100000 * time.Second:     "100000S",
+		100000000 * time.Second:  "1666667M",
+		1<<63 - 1:                "2562048H",
+	}
+	for in, expected := range cases {
+		assert.Equal(t, expected, encodeTimeout(in), "%s", in)
+	}
+}
+
+func TestHTTPTransportTimeout(t *testing.T) {
+	var timeout string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout = r.Header.Get("grpc-timeout")
+	}))
+	defer srv.Close()
+
+	send := func(ctx context.Context) error {
+		tr := HTTPTransportBuilderWithOptions(strings.TrimPrefix(srv.URL, "http://"), &Request{endpoint: "/api.Example/Unary"}, &TransportOptions{Insecure: true})
+		_, err := tr.Send(ctx, bytes.NewReader(nil))
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.NoError(t, send(ctx))
+	assert.NotEmpty(t, timeout)
+	assert.NotEqual(t, "0", timeout[:1])
+
+	timeout = ""
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	defer cancel()
+	err := send(ctx)
+	assert.Equal(t, context.DeadlineExceeded, pkgerrors.Cause(err))
+	assert.Empty(t, timeout, "the request must not be sent")
+}
+
+func TestHTTPTransportAuthority(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		host = r.Host
+	}))
+	defer srv.Close()
+
+	tr := HTTPTransportBuilderWithOptions(strings.TrimPrefix(srv.URL, "http://"), &Request{endpoint: "/api.Example/Unary"}, &TransportOptions{
+		Insecure:  true,
+		Authority: "api.example.com",
+	})
+	_, err := tr.Send(context.Background(), bytes.NewReader(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.com", host)
+}
+
+func TestTransportOptionsAcceptEncoding(t *testing.T) {
+	cases := map[string]struct {
+		opts     TransportOptions
+		expected string
+	}{
+		"none":               {},
+		"compression":        {opts: TransportOptions{Compression: "gzip"}, expected: "gzip"},
+		"accepted":           {opts: TransportOptions{AcceptCompression: []string{"gzip", "snappy"}}, expected: "gzip,snappy"},
+		"both without dupes": {opts: TransportOptions{Compression: "gzip", AcceptCompression: []string{"snappy", "gzip"}}, expected: "gzip,snappy"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.expected, c.opts.acceptEncoding())
+		})
+	}
+}
+
+func TestTransportReused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/grpc-web+proto")
+	}))
+	defer srv.Close()
+
+	builders := map[string]TransportBuilderWithOptions{
+		"HTTPTransport":    HTTPTransportBuilderWithOptions,
+		"ConnectTransport": ConnectTransportBuilder,
+	}
+	for name, b := range builders {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			tr := b(strings.TrimPrefix(srv.URL, "http://"), &Request{endpoint: "/api.Example/Unary"}, &TransportOptions{Insecure: true})
+			_, err := tr.Send(context.Background(), bytes.NewReader(frame(0x00, nil)))
+			require.NoError(t, err)
+			_, err = tr.Send(context.Background(), bytes.NewReader(frame(0x00, nil)))
+			assert.Equal(t, ErrTransportReused, err)
+		})
+	}
+}
+
+func TestHTTPTransportRedirect(t *testing.T) {
+	type received struct {
+		method, body, auth string
+	}
+	var got received
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got = received{method: r.Method, body: string(b), auth: r.Header.Get("authorization")}
+		w.Header().Set("content-type", "application/grpc-web+proto")
+	}))
+	defer target.Close()
+	// the same server as target, but it is another host for redirects.
+	otherHost := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, target.URL+"/api.Example/Unary", http.StatusMovedPermanently)
+		case "/other":
+			http.Redirect(w, r, otherHost+"/api.Example/Unary", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer srv.Close()
+
+	send := func(endpoint string, follow bool) error {
+		got = received{}
+		opts := &TransportOptions{Insecure: true, FollowRedirects: follow}
+		opts.HTTPClient = newHTTPClient(opts)
+		tr := HTTPTransportBuilderWithOptions(strings.TrimPrefix(srv.URL, "http://"), &Request{endpoint: endpoint}, opts)
+		ctx := withHeader(context.Background(), metadata.Pairs("authorization", "Bearer token"))
+		_, err := tr.Send(ctx, bytes.NewBufferString("body"))
+		return err
+	}
+
+	t.Run("redirects are errors by default", func(t *testing.T) {
+		err := send("/same", false)
+		rerr, ok := pkgerrors.Cause(err).(*RedirectError)
+		require.True(t, ok, "expected *RedirectError, but got %T", pkgerrors.Cause(err))
+		assert.Equal(t, http.StatusMovedPermanently, rerr.StatusCode)
+		assert.Equal(t, target.URL+"/api.Example/Unary", rerr.Location)
+		assert.Equal(t, received{}, got, "the redirect must not be followed")
+	})
+
+	t.Run("WithFollowRedirects preserves the request", func(t *testing.T) {
+		require.NoError(t, send("/same", true))
+		assert.Equal(t, received{method: http.MethodPost, body: "body", auth: "Bearer token"}, got)
+
+		require.NoError(t, send("/other", true))
+		assert.Equal(t, received{method: http.MethodPost, body: "body"}, got, "credentials must not be sent to another host")
+
+		err := send("/loop", true)
+		_, ok := pkgerrors.Cause(err).(*RedirectError)
+		assert.True(t, ok, "expected *RedirectError, but got %T", pkgerrors.Cause(err))
+	})
+}
+
+func TestHTTPTransportResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		if r.URL.Path == "/api.Example/Slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		// the headers arrive promptly, but the body takes longer than the timeout.
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	send := func(endpoint string) (io.ReadCloser, error) {
+		tr := HTTPTransportBuilderWithOptions(strings.TrimPrefix(srv.URL, "http://"), &Request{endpoint: endpoint}, &TransportOptions{
+			Insecure:              true,
+			ResponseHeaderTimeout: 50 * time.Millisecond,
+		})
+		return tr.Send(context.Background(), bytes.NewReader(nil))
+	}
+
+	_, err := send("/api.Example/Slow")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "%v", err)
+
+	client := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithResponseHeaderTimeout(50*time.Millisecond))
+	defer client.Close()
+	_, err = client.Unary(context.Background(), NewRequest("/api.Example/Slow", &wrappers.StringValue{}, &wrappers.StringValue{}))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "%v", err)
+
+	body, err := send("/api.Example/Unary")
+	require.NoError(t, err)
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(b))
+}
+
+func TestHeaderTimer(t *testing.T) {
+	t.Run("the timer fires after the timeout", func(t *testing.T) {
+		canceled := make(chan struct{})
+		timer := startHeaderTimer(10*time.Millisecond, func() { close(canceled) })
+		<-canceled
+		assert.False(t, timer.stop())
+	})
+
+	t.Run("the timer doesn't cancel a response which has arrived in time", func(t *testing.T) {
+		var canceled bool
+		timer := startHeaderTimer(time.Hour, func() { canceled = true })
+		assert.True(t, timer.stop())
+		// the callback may already be running when stop is called.
+		timer.fire()
+		assert.False(t, canceled)
+	})
+}
+
+func TestWebSocketTransportKeepalive(t *testing.T) {
+	cases := map[string]struct {
+		ignorePing bool
+		wantErr    bool
+	}{
+		"pong arrives":   {},
+		"pong is missed": {ignorePing: true, wantErr: true},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+				if c.ignorePing {
+					conn.SetPingHandler(func(string) error { return nil })
+				}
+				go func() {
+					<-done
+					// response header and a response.
+					conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+					conn.WriteMessage(websocket.BinaryMessage, []byte("grpc-status: 0\r\n"))
+					conn.WriteMessage(websocket.BinaryMessage, frame(0x00, []byte("foo"))[:headerLen])
+					conn.WriteMessage(websocket.BinaryMessage, []byte("foo"))
+				}()
+				// handle control messages.
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			})
+			defer srv.Close()
+
+			tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+				Insecure:          true,
+				KeepaliveInterval: 50 * time.Millisecond,
+				KeepaliveTimeout:  50 * time.Millisecond,
+			})
+			require.NoError(t, err)
+			defer tr.Close()
+
+			time.AfterFunc(500*time.Millisecond, func() { close(done) })
+			_, err = tr.Receive()
+			if c.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketTransportKeepaliveIdle(t *testing.T) {
+	cases := map[string]struct {
+		ignorePing bool
+		wantErr    bool
+	}{
+		"pong arrives":   {},
+		"pong is missed": {ignorePing: true, wantErr: true},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			srv := newWebSocketServer(t, func(conn *websocket.Conn) {
+				if c.ignorePing {
+					conn.SetPingHandler(func(string) error { return nil })
+				}
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			})
+			defer srv.Close()
+
+			tr, err := WebSocketTransportBuilderWithOptions(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/api.Example/BidiStreaming", &TransportOptions{
+				Insecure:          true,
+				KeepaliveInterval: 50 * time.Millisecond,
+				KeepaliveTimeout:  50 * time.Millisecond,
+			})
+			require.NoError(t, err)
+			defer tr.Close()
+
+			// nothing reads from the connection in the meantime.
+			time.Sleep(300 * time.Millisecond)
+			err = tr.Send(bytes.NewReader(frame(0x00, []byte("foo"))))
+			if c.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+package grpcweb
+
+// Version is the version of grpc-web-go-client, which is sent in the default User-Agent.
+// It is "dev" until the maintainer sets it to the tag when cutting a release.
+const Version = "dev"